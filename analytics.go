@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	sm "dev.hackerman.me/artheon/veverse-shared/model"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// sessionAnalyticsTable is the table of the session lifecycle analytics rows
+const sessionAnalyticsTable = "launcher_sessions"
+
+// analyticsFlushTimeout is the time the session analytics row is flushed for before the launcher may exit
+const analyticsFlushTimeout = 5 * time.Second
+
+// sessionAnalytics collects the lifecycle timings of the session, written as a single analytics row when the session ends
+type sessionAnalytics struct {
+	mu             sync.Mutex
+	startedAt      time.Time // Time the launcher has started waiting for a session
+	sessionId      string
+	appId          string
+	releaseVersion string
+	claimedAt      time.Time
+	downloadBytes  uint64
+	downloadTime   time.Duration
+	extractTime    time.Duration
+	readyAt        time.Time
+	restarts       int
+	finished       bool
+}
+
+// analytics is the analytics of the current session
+var analytics = &sessionAnalytics{startedAt: time.Now()}
+
+// Claim starts collecting the analytics of the claimed session
+func (a *sessionAnalytics) Claim(session *sm.PixelStreamingSessionData) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.claimedAt = time.Now()
+	if session.Id != nil {
+		a.sessionId = session.Id.String()
+	}
+	if session.AppId != nil {
+		a.appId = session.AppId.String()
+	}
+}
+
+// Reset starts collecting the analytics of the next session, the launcher waits for it from now on
+func (a *sessionAnalytics) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.startedAt = time.Now()
+	a.sessionId = ""
+	a.appId = ""
+	a.releaseVersion = ""
+	a.claimedAt = time.Time{}
+	a.downloadBytes = 0
+	a.downloadTime = 0
+	a.extractTime = 0
+	a.readyAt = time.Time{}
+	a.restarts = 0
+	a.finished = false
+}
+
+// SetRelease sets the version of the session app release
+func (a *sessionAnalytics) SetRelease(version string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.releaseVersion = version
+}
+
+// RecordDownload adds the downloaded release files
+func (a *sessionAnalytics) RecordDownload(bytes uint64, duration time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.downloadBytes += bytes
+	a.downloadTime += duration
+}
+
+// RecordExtract adds the release archive extraction time
+func (a *sessionAnalytics) RecordExtract(duration time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.extractTime += duration
+}
+
+// MarkReady records the first time the app has registered at the signalling server
+func (a *sessionAnalytics) MarkReady() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.readyAt.IsZero() {
+		a.readyAt = time.Now()
+	}
+}
+
+// RecordRestart counts the app restart
+func (a *sessionAnalytics) RecordRestart() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.restarts++
+}
+
+// Finish writes the session analytics row once and waits for it to be flushed, as the launcher may exit right after.
+// The exit code is -1 if the app has not been started.
+func (a *sessionAnalytics) Finish(status string, reason string, exitCode int) {
+	a.mu.Lock()
+	if a.finished || a.claimedAt.IsZero() {
+		a.mu.Unlock()
+		return
+	}
+	a.finished = true
+
+	now := time.Now()
+	row := map[string]interface{}{
+		"session_id":      a.sessionId,
+		"instance_id":     instanceId,
+		"app_id":          a.appId,
+		"release_version": a.releaseVersion,
+		"time_to_claim":   a.claimedAt.Sub(a.startedAt).Seconds(),
+		"download_time":   a.downloadTime.Seconds(),
+		"download_bytes":  int64(a.downloadBytes),
+		"extract_time":    a.extractTime.Seconds(),
+		"time_to_ready":   0.0,
+		"duration":        now.Sub(a.claimedAt).Seconds(),
+		"restarts":        int64(a.restarts),
+		"exit_code":       int64(exitCode),
+		"status":          status,
+		"close_reason":    reason,
+	}
+	if !a.readyAt.IsZero() {
+		row["time_to_ready"] = a.readyAt.Sub(a.claimedAt).Seconds()
+	}
+	a.mu.Unlock()
+
+	telemetryQueue.Emit(sessionAnalyticsTable, row)
+
+	ctx, cancel := context.WithTimeout(context.Background(), analyticsFlushTimeout)
+	defer cancel()
+
+	err := telemetryQueue.Flush(ctx)
+	if err != nil {
+		logrus.Warningf("failed to flush the session analytics: %s\n", err.Error())
+	}
+}
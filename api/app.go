@@ -12,18 +12,19 @@ import (
 	"github.com/sirupsen/logrus"
 	"io"
 	"net/http"
-	"os"
-	_ "veverse-pixel-streaming-launcher/config"
+	"veverse-pixel-streaming-launcher/config"
+	"veverse-pixel-streaming-launcher/metrics"
 )
 
-var api2Root string
+// api2Root is the API root URL, set from the launcher configuration with SetRoot
+var api2Root = config.Api2Url
 
-func init() {
-	api2Root = os.Getenv("VE_API2_ROOT_URL")
+// apiClient is the HTTP client of the API requests recording the request metrics
+var apiClient = &http.Client{Transport: metrics.NewTransport(http.DefaultTransport)}
 
-	if api2Root == "" {
-		logrus.Fatalf("invalid VE_API2_ROOT_URL env\n")
-	}
+// SetRoot sets the API root URL of the requests
+func SetRoot(root string) {
+	api2Root = root
 }
 
 // GetLatestReleaseV2 returns the latest release metadata for the given app id.
@@ -40,8 +41,7 @@ func GetLatestReleaseV2(ctx context.Context, id uuid.UUID) (*sm.ReleaseV2, error
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send a HTTP GET request: %w", err)
 	}
@@ -73,9 +73,10 @@ func GetLatestReleaseV2(ctx context.Context, id uuid.UUID) (*sm.ReleaseV2, error
 
 	var latestVersion *semver.Version
 	var latestRelease *sm.ReleaseV2
-	for _, release := range v.Payload.Releases.Entities {
+	for i := range v.Payload.Releases.Entities {
+		release := &v.Payload.Releases.Entities[i]
 		if latestVersion == nil {
-			latestRelease = &release
+			latestRelease = release
 			latestVersion, err = semver.NewVersion(release.Version)
 			if err != nil {
 				logrus.Errorf("failed to parse version: %s", err)
@@ -88,9 +89,9 @@ func GetLatestReleaseV2(ctx context.Context, id uuid.UUID) (*sm.ReleaseV2, error
 				return nil, fmt.Errorf("failed to parse semver: %w", err)
 			}
 
-			if latestVersion.GreaterThan(releaseVersion) {
+			if releaseVersion.GreaterThan(latestVersion) {
 				latestVersion = releaseVersion
-				latestRelease = &release
+				latestRelease = release
 			}
 		}
 	}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveApi points the API root at the test server until the test ends
+func serveApi(t *testing.T, handler http.HandlerFunc) {
+	srv := httptest.NewServer(handler)
+	root := api2Root
+	SetRoot(srv.URL)
+	t.Cleanup(func() {
+		SetRoot(root)
+		srv.Close()
+	})
+}
+
+func TestGetLatestReleaseV2(t *testing.T) {
+	id := uuid.Must(uuid.NewV4())
+
+	serveApi(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apps/public/"+id.String() {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("platform") == "" {
+			http.Error(w, "platform is not set", http.StatusBadRequest)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"status":"ok","data":{"id":"` + id.String() + `","releases":{"entities":[
+			{"version":"1.2.0"},{"version":"1.10.0"},{"version":"1.9.3"}
+		]}}}`))
+	})
+
+	release, err := GetLatestReleaseV2(context.Background(), id)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if release.Version != "1.10.0" {
+		t.Errorf("got release %s, want 1.10.0", release.Version)
+	}
+}
+
+func TestGetLatestReleaseV2Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		body string
+	}{
+		{name: "api error", code: http.StatusNotFound, body: `{"status":"error","message":"not found"}`},
+		{name: "no releases", code: http.StatusOK, body: `{"status":"ok","data":{"releases":{"entities":[]}}}`},
+		{name: "invalid version", code: http.StatusOK, body: `{"status":"ok","data":{"releases":{"entities":[{"version":"latest"}]}}}`},
+		{name: "invalid response", code: http.StatusOK, body: `<html></html>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serveApi(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.code)
+				_, _ = w.Write([]byte(tt.body))
+			})
+
+			release, err := GetLatestReleaseV2(context.Background(), uuid.Must(uuid.NewV4()))
+			if err == nil {
+				t.Errorf("expected error, got release %+v", release)
+			}
+		})
+	}
+}
+
+func TestGetLatestReleaseV2NilId(t *testing.T) {
+	serveApi(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s", r.URL)
+	})
+
+	_, err := GetLatestReleaseV2(context.Background(), uuid.Nil)
+	if err == nil {
+		t.Errorf("expected error for the nil app id")
+	}
+}
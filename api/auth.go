@@ -10,7 +10,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	glConfig "veverse-pixel-streaming-launcher/config"
 )
 
 func Login(ctx context.Context, email string, password string) (context.Context, error) {
@@ -28,7 +27,7 @@ func Login(ctx context.Context, email string, password string) (context.Context,
 		log.Fatalln(err)
 	}
 
-	url := fmt.Sprintf("%s/auth/login", glConfig.Api2Url)
+	url := fmt.Sprintf("%s/auth/login", api2Root)
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
 	if err != nil {
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	sm "dev.hackerman.me/artheon/veverse-shared/model"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	"veverse-pixel-streaming-launcher/websocket"
+)
+
+// Session assignment modes
+const (
+	assignmentPoll      = "poll"      // Poll for the pending session at the jittered interval
+	assignmentLongPoll  = "longpoll"  // The API holds the poll request until a session is pending
+	assignmentWebSocket = "websocket" // The API notifies about the pending sessions over the WebSocket subscription
+)
+
+// maxRejectedSessions is the number of the latest rejected sessions excluded from the polls
+const maxRejectedSessions = 32
+
+// sessionAssigner waits for the pending session assigned to the instance. The poll interval is jittered so the idle
+// instances do not poll the API in lockstep, and grows after the failures.
+type sessionAssigner struct {
+	drain      *drainController
+	wake       chan struct{} // Notified by the WebSocket subscription
+	subscribed atomic.Bool
+	failures   atomic.Int32
+	rejected   []string // Ids of the sessions the instance can not run, excluded from the next polls
+
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+// newSessionAssigner creates a new sessionAssigner, waiting for a session stops once the instance starts draining
+func newSessionAssigner(drain *drainController) *sessionAssigner {
+	return &sessionAssigner{
+		drain: drain,
+		wake:  make(chan struct{}, 1),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Next waits for the pending session and claims it, nil is returned if the instance has started draining or the context
+// is cancelled. The prefetch is cancelled before every claim so the release download of the session does not compete
+// with it, and is resumed if the claim fails.
+func (a *sessionAssigner) Next(ctx context.Context, prefetcher *releasePrefetcher) *sm.PixelStreamingSessionData {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-a.drain.Started():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	mode := cfg().Session.AssignmentMode
+	if mode == assignmentWebSocket {
+		go a.subscribe(ctx)
+	}
+
+	for ctx.Err() == nil {
+		var wait time.Duration
+		if mode == assignmentLongPoll {
+			wait = cfg().Session.LongPollWait
+		}
+
+		caps := collectCapabilities()
+		startedAt := time.Now()
+		session, requirements, err := GetPendingSession(ctx, wait, caps, a.rejected)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			a.failures.Add(1)
+			logrus.Errorf("failed to get pending session: %s\n", err.Error())
+		} else {
+			a.failures.Store(0)
+			if session != nil && session.Id != nil {
+				if err = caps.Check(requirements); err != nil {
+					// Poll for another session at once unless the API keeps offering the rejected one
+					if a.reject(session.Id.String(), err) {
+						continue
+					}
+				} else {
+					prefetcher.Cancel()
+					session, err = claimSession(ctx, session)
+					if session != nil {
+						return session
+					}
+					prefetcher.Resume()
+					// Another instance has claimed the session first or the claim has failed, go back to polling
+					if err != nil {
+						a.failures.Add(1)
+						logrus.Errorf("%s\n", err.Error())
+					}
+				}
+			} else if wait > 0 && time.Since(startedAt) >= wait/2 {
+				// The held request has already waited for the session, the API not holding the request is polled at the interval
+				continue
+			}
+		}
+
+		a.sleep(ctx, a.interval())
+	}
+
+	return nil
+}
+
+// reject excludes the session the instance does not meet the requirements of from the next polls, so the scheduler
+// routes it elsewhere. Returns false if the session has already been rejected.
+func (a *sessionAssigner) reject(id string, reason error) bool {
+	for _, r := range a.rejected {
+		if r == id {
+			return false
+		}
+	}
+
+	logrus.Infof("rejecting the session %s: %s", id, reason.Error())
+	a.rejected = append(a.rejected, id)
+	if len(a.rejected) > maxRejectedSessions {
+		a.rejected = a.rejected[len(a.rejected)-maxRejectedSessions:]
+	}
+
+	return true
+}
+
+// interval returns the jittered poll interval, the interval grows after the failures and while the pending sessions are
+// pushed by the WebSocket subscription
+func (a *sessionAssigner) interval() time.Duration {
+	c := cfg().Session
+
+	d := c.CheckInterval
+	if a.subscribed.Load() {
+		d = c.MaxCheckInterval
+	}
+	for i := int32(0); i < a.failures.Load() && d < c.MaxCheckInterval; i++ {
+		d *= 2
+	}
+	if d > c.MaxCheckInterval {
+		d = c.MaxCheckInterval
+	}
+
+	return a.jitter(d, c.CheckJitter)
+}
+
+// jitter spreads the duration randomly by the fraction in both directions
+func (a *sessionAssigner) jitter(d time.Duration, fraction float64) time.Duration {
+	a.randMu.Lock()
+	defer a.randMu.Unlock()
+
+	return d + time.Duration((a.rand.Float64()*2-1)*fraction*float64(d))
+}
+
+// sleep waits for the duration, the pending session notification or the context cancellation
+func (a *sessionAssigner) sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-a.wake:
+	case <-timer.C:
+	}
+}
+
+// subscribe keeps the WebSocket subscription to the pending session notifications until the context is cancelled,
+// reconnecting after the failure. Every notification wakes the poll, so the session is claimed the usual way.
+func (a *sessionAssigner) subscribe(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := a.listen(ctx)
+		a.subscribed.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+
+		retry := a.interval()
+		if errors.Is(err, websocket.ErrNotSupported) {
+			// The unsupported subscription is not retried at the poll rate
+			retry = a.jitter(cfg().Session.MaxCheckInterval, cfg().Session.CheckJitter)
+			logrus.Warningf("the API does not support the session subscription, falling back to polling: %s", err.Error())
+		} else {
+			logrus.Errorf("session subscription failed, falling back to polling: %s\n", err.Error())
+		}
+
+		a.sleep(ctx, retry)
+	}
+}
+
+// listen receives the pending session notifications until the connection fails or the context is cancelled
+func (a *sessionAssigner) listen(ctx context.Context) error {
+	url := fmt.Sprintf("%s/pixelstreaming/session/subscribe", api2Root)
+
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", ctx.Value("token")))
+
+	conn, err := websocket.Dial(ctx, apiClient, url, header)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+
+	logrus.Infof("subscribed to the pending session notifications")
+	a.subscribed.Store(true)
+
+	for {
+		_, err = conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case a.wake <- struct{}{}:
+		default:
+		}
+	}
+}
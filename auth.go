@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Control API request signature headers
+const (
+	headerTimestamp = "X-Launcher-Timestamp"
+	headerSignature = "X-Launcher-Signature"
+)
+
+// requestAuthenticator verifies the HMAC-SHA256 signatures of the control API requests. The signature is calculated over
+// the request method, URI, unix timestamp and the SHA256 of the body using the per-session handshake secret, or the
+// fallback shared secret while there is no session.
+type requestAuthenticator struct {
+	mu       sync.RWMutex
+	secret   []byte
+	fallback []byte
+	maxSkew  time.Duration // Maximum difference between the request timestamp and the launcher clock
+}
+
+// newRequestAuthenticator creates a new requestAuthenticator with the optional fallback shared secret
+func newRequestAuthenticator(fallback string, maxSkew time.Duration) *requestAuthenticator {
+	return &requestAuthenticator{
+		fallback: []byte(fallback),
+		maxSkew:  maxSkew,
+	}
+}
+
+// SetSecret sets the per-session handshake secret
+func (a *requestAuthenticator) SetSecret(secret string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.secret = []byte(secret)
+}
+
+// key returns the secret used to verify the signatures, nil if no secret is available
+func (a *requestAuthenticator) key() []byte {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if len(a.secret) > 0 {
+		return a.secret
+	}
+	if len(a.fallback) > 0 {
+		return a.fallback
+	}
+	return nil
+}
+
+// Middleware rejects the requests without a valid signature
+func (a *requestAuthenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reject := func(reason string) {
+			logrus.Warningf("rejected unauthenticated control request %s %s from %s: %s", r.Method, r.URL.RequestURI(), r.RemoteAddr, reason)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+		}
+
+		key := a.key()
+		if key == nil {
+			reject("no secret available")
+			return
+		}
+
+		timestamp := r.Header.Get(headerTimestamp)
+		signature := r.Header.Get(headerSignature)
+		if timestamp == "" || signature == "" {
+			reject("missing signature")
+			return
+		}
+
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			reject("invalid timestamp")
+			return
+		}
+
+		skew := time.Since(time.Unix(ts, 0))
+		if skew > a.maxSkew || skew < -a.maxSkew {
+			reject("timestamp out of range")
+			return
+		}
+
+		var body []byte
+		if r.Body != nil {
+			body, err = io.ReadAll(r.Body)
+			if err != nil {
+				reject("failed to read body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		expected, err := hex.DecodeString(signature)
+		if err != nil || !hmac.Equal(expected, signRequest(key, r.Method, r.URL.RequestURI(), timestamp, body)) {
+			reject("invalid signature")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// signRequest calculates the request signature
+func signRequest(key []byte, method string, uri string, timestamp string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + hex.EncodeToString(bodyHash[:])))
+	return mac.Sum(nil)
+}
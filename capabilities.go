@@ -0,0 +1,110 @@
+package main
+
+import (
+	vUnreal "dev.hackerman.me/artheon/veverse-shared/unreal"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"veverse-pixel-streaming-launcher/system"
+)
+
+// instanceCapabilities are the instance capabilities advertised to the scheduler when polling for a pending session
+type instanceCapabilities struct {
+	InstanceType  string
+	Platform      string
+	Region        string
+	GpuClass      string
+	FreeDiskBytes uint64
+	Apps          []string // Ids of the apps with a release installed in the apps directory
+}
+
+// sessionRequirements are the instance requirements of the session app, the empty requirement is met by any instance
+type sessionRequirements struct {
+	InstanceType     string `json:"instanceType,omitempty"`
+	Platform         string `json:"platform,omitempty"`
+	Region           string `json:"region,omitempty"`
+	GpuClass         string `json:"gpuClass,omitempty"`
+	MinFreeDiskBytes uint64 `json:"minFreeDiskBytes,omitempty"`
+}
+
+// collectCapabilities gathers the current instance capabilities
+func collectCapabilities() instanceCapabilities {
+	c := instanceCapabilities{
+		InstanceType: cfg().Instance.Type,
+		Platform:     vUnreal.GetPlatformName(),
+		Region:       cfg().Instance.Region,
+		GpuClass:     cfg().Instance.GpuClass,
+	}
+
+	var err error
+	c.FreeDiskBytes, err = system.FreeDisk(".")
+	if err != nil {
+		logrus.Debugf("failed to get free disk space: %s", err.Error())
+	}
+
+	seen := map[string]bool{}
+	for _, r := range cachedReleases() {
+		if !seen[r.AppId] {
+			seen[r.AppId] = true
+			c.Apps = append(c.Apps, r.AppId)
+		}
+	}
+	sort.Strings(c.Apps)
+
+	return c
+}
+
+// Query encodes the capabilities as the pending session query parameters, the unset capabilities are omitted
+func (c instanceCapabilities) Query() url.Values {
+	q := url.Values{}
+	set := func(key string, value string) {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+
+	set("instanceId", instanceId)
+	set("instanceType", c.InstanceType)
+	set("platform", c.Platform)
+	set("region", c.Region)
+	set("gpuClass", c.GpuClass)
+	q.Set("freeDiskBytes", strconv.FormatUint(c.FreeDiskBytes, 10))
+	set("apps", strings.Join(c.Apps, ","))
+
+	return q
+}
+
+// Check returns the error describing the first requirement the instance does not meet, nil requirements are always met
+func (c instanceCapabilities) Check(r *sessionRequirements) error {
+	if r == nil {
+		return nil
+	}
+
+	match := func(name string, required string, actual string) error {
+		if required != "" && !strings.EqualFold(required, actual) {
+			return fmt.Errorf("%s %q is required, the instance has %q", name, required, actual)
+		}
+		return nil
+	}
+
+	if err := match("instance type", r.InstanceType, c.InstanceType); err != nil {
+		return err
+	}
+	if err := match("platform", r.Platform, c.Platform); err != nil {
+		return err
+	}
+	if err := match("region", r.Region, c.Region); err != nil {
+		return err
+	}
+	if err := match("gpu class", r.GpuClass, c.GpuClass); err != nil {
+		return err
+	}
+	if r.MinFreeDiskBytes > c.FreeDiskBytes {
+		return fmt.Errorf("%d bytes of free disk space are required, the instance has %d", r.MinFreeDiskBytes, c.FreeDiskBytes)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	sm "dev.hackerman.me/artheon/veverse-shared/model"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"sync/atomic"
+	"time"
+)
+
+// closeReasonLeaseLost is the analytics close reason when the session claim lease has been lost before the app has started
+const closeReasonLeaseLost = "lease-lost"
+
+// sessionLease renews the lease of the claimed session while the launcher prepares and starts the app, the API takes the
+// session back to pending once the lease expires, e.g. when the instance has gone down during the installation
+type sessionLease struct {
+	ctx     context.Context // Cancelled once the lease has been lost or stopped
+	cancel  context.CancelFunc
+	manager *sessionManager
+	id      *uuid.UUID
+	lost    atomic.Bool
+}
+
+// newSessionLease creates a new sessionLease of the session claimed by the instance
+func newSessionLease(ctx context.Context, manager *sessionManager, id *uuid.UUID) *sessionLease {
+	ctx, cancel := context.WithCancel(ctx)
+	return &sessionLease{
+		ctx:     ctx,
+		cancel:  cancel,
+		manager: manager,
+		id:      id,
+	}
+}
+
+// Context returns the context cancelled once the lease has been lost, the session preparation is bound to it
+func (l *sessionLease) Context() context.Context {
+	return l.ctx
+}
+
+// Stop stops renewing the lease
+func (l *sessionLease) Stop() {
+	l.cancel()
+}
+
+// Lost checks if the lease has been lost and the session belongs to another instance now
+func (l *sessionLease) Lost() bool {
+	return l.lost.Load()
+}
+
+// Run renews the lease at a third of its duration until the app is running or the lease is stopped. Once the lease has
+// been lost the installation is cancelled and the session is closed locally without reporting its status, as it is not
+// owned by the instance, the starting app is stopped.
+func (l *sessionLease) Run() {
+	for {
+		lease := cfg().Session.ClaimLease
+
+		timer := time.NewTimer(lease / 3)
+		select {
+		case <-l.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		phase := l.manager.Status().Phase
+		if phase != phaseIdle && phase != phaseInstalling && phase != phaseStarting {
+			return
+		}
+
+		err := RenewSessionLease(l.ctx, l.id, lease)
+		if errors.Is(err, errClaimConflict) {
+			logrus.Errorf("the lease of the session %s has been lost, the session is not prepared\n", l.id)
+			l.lost.Store(true)
+			l.cancel()
+			l.manager.Abandon(closeReasonLeaseLost)
+			return
+		} else if err != nil {
+			logrus.Warningf("failed to renew the lease of the session %s: %s", l.id, err.Error())
+		}
+	}
+}
+
+// claimSession claims the pending session for the instance, nil is returned if another instance has claimed it first
+func claimSession(ctx context.Context, pending *sm.PixelStreamingSessionData) (*sm.PixelStreamingSessionData, error) {
+	session, err := ClaimSession(ctx, pending.Id, cfg().Session.ClaimLease)
+	if errors.Is(err, errClaimConflict) {
+		logrus.Infof("the session %s has been claimed by another instance", pending.Id)
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to claim the session %s: %w", pending.Id, err)
+	}
+
+	if session == nil || session.Id == nil {
+		session = pending
+	}
+
+	return session, nil
+}
@@ -11,4 +11,6 @@ const (
 	TempDir     = ".tmp"
 	DownloadDir = "downloads"
 	AppDir      = "apps"
+	SessionDir  = "sessions"
+	OutboxDir   = "outbox"
 )
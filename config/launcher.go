@@ -0,0 +1,624 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redacted replaces the secret values in the printed configuration
+const redacted = "<redacted>"
+
+// Launcher is the runtime configuration of the launcher. The values are layered with increasing precedence: the defaults,
+// the configuration file (YAML or JSON), the environment variables and the command line flags.
+type Launcher struct {
+	Environment string `yaml:"environment"` // Entrypoint binary flavour: dev, test or prod
+	InstanceId  string `yaml:"instanceId"`
+	LogLevel    string `yaml:"logLevel"`
+	HooksFile   string `yaml:"hooksFile"`
+	// LocalRelease is the local directory or zip archive of the app release run in the offline mode without the API
+	LocalRelease string         `yaml:"localRelease"`
+	Api          ApiConfig      `yaml:"api"`
+	Session      SessionConfig  `yaml:"session"`
+	Instance     InstanceConfig `yaml:"instance"`
+	App          AppConfig      `yaml:"app"`
+	Control      ControlConfig  `yaml:"control"`
+	Dirs         DirsConfig     `yaml:"dirs"`
+	ClickHouse   ClickHouse     `yaml:"clickhouse"`
+	Telemetry    Telemetry      `yaml:"telemetry"`
+	Prefetch     PrefetchConfig `yaml:"prefetch"`
+}
+
+// ApiConfig is the API connection configuration
+type ApiConfig struct {
+	Url      string `yaml:"url"`
+	Email    string `yaml:"email"`
+	Password string `yaml:"password"`
+}
+
+// SessionConfig is the session lifecycle configuration
+type SessionConfig struct {
+	CheckInterval          time.Duration `yaml:"checkInterval"`    // Pending session poll interval
+	MaxCheckInterval       time.Duration `yaml:"maxCheckInterval"` // Poll interval after the failures and while subscribed to the assignments
+	CheckJitter            float64       `yaml:"checkJitter"`      // Random spread of the poll interval, the fraction of the interval
+	AssignmentMode         string        `yaml:"assignmentMode"`   // poll, longpoll or websocket
+	LongPollWait           time.Duration `yaml:"longPollWait"`     // Time the API holds the long-poll request waiting for a session
+	ClaimLease             time.Duration `yaml:"claimLease"`       // Lease of the claimed session, renewed until the app has started
+	StartupTimeout         time.Duration `yaml:"startupTimeout"`
+	ReadinessCheckInterval time.Duration `yaml:"readinessCheckInterval"`
+	WatchdogCheckInterval  time.Duration `yaml:"watchdogCheckInterval"`
+	HeartbeatTimeout       time.Duration `yaml:"heartbeatTimeout"`
+	MaxDuration            time.Duration `yaml:"maxDuration"` // Zero means no limit
+	IdleTimeout            time.Duration `yaml:"idleTimeout"` // Zero means no limit
+	StopWarning            time.Duration `yaml:"stopWarning"`
+	StopTimeout            time.Duration `yaml:"stopTimeout"`
+}
+
+// InstanceConfig is the instance heartbeat and capabilities configuration
+type InstanceConfig struct {
+	Type              string        `yaml:"type"`     // Instance type advertised to the scheduler, e.g. g4dn.xlarge
+	Region            string        `yaml:"region"`   // Empty means the instance does not advertise the region
+	GpuClass          string        `yaml:"gpuClass"` // Empty means the instance does not advertise the GPU class
+	HeartbeatInterval time.Duration `yaml:"heartbeatInterval"`
+	RetryInterval     time.Duration `yaml:"retryInterval"` // First retry delay of the failed heartbeat, doubled up to the heartbeat interval
+	DrainTimeout      time.Duration `yaml:"drainTimeout"`  // Time the session may run after the drain has started, zero means no limit
+}
+
+// AppConfig is the app process configuration
+type AppConfig struct {
+	PixelStreamingIP   string        `yaml:"pixelStreamingIp"`
+	PixelStreamingPort int           `yaml:"pixelStreamingPort"`
+	ResX               int           `yaml:"resX"`
+	ResY               int           `yaml:"resY"`
+	User               string        `yaml:"user"`
+	Group              string        `yaml:"group"`          // Empty means the user primary group
+	EnvAllowlist       []string      `yaml:"envAllowlist"`   // Empty means the launcher default list
+	MemoryLimitMB      uint64        `yaml:"memoryLimitMb"`  // Zero means no limit
+	CPUWeight          uint64        `yaml:"cpuWeight"`      // Zero means no limit
+	OpenFilesLimit     uint64        `yaml:"openFilesLimit"` // Zero means no limit
+	SampleInterval     time.Duration `yaml:"sampleInterval"`
+}
+
+// ControlConfig is the control server configuration
+type ControlConfig struct {
+	Address         string        `yaml:"address"`
+	Secret          string        `yaml:"secret"` // Used until the session secret is received from the API
+	MaxClockSkew    time.Duration `yaml:"maxClockSkew"`
+	ReadTimeout     time.Duration `yaml:"readTimeout"`
+	WriteTimeout    time.Duration `yaml:"writeTimeout"`
+	IdleTimeout     time.Duration `yaml:"idleTimeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// Telemetry is the analytics events configuration
+type Telemetry struct {
+	Sink          string        `yaml:"sink"` // clickhouse, file or none
+	File          string        `yaml:"file"` // Events file of the file sink
+	QueueSize     int           `yaml:"queueSize"`
+	BatchSize     int           `yaml:"batchSize"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	WriteTimeout  time.Duration `yaml:"writeTimeout"`
+	SpillDir      string        `yaml:"spillDir"` // Outbox of the events waiting for the sink to become available, empty disables the spill
+	MaxSpillMB    int64         `yaml:"maxSpillMb"`
+}
+
+// PrefetchConfig is the configuration of the app releases prefetched while the launcher waits for a session
+type PrefetchConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Apps          []string      `yaml:"apps"`          // Ids of the prefetched apps, empty means the hot apps reported by the API
+	MaxApps       int           `yaml:"maxApps"`       // Number of the hot apps prefetched
+	Interval      time.Duration `yaml:"interval"`      // Interval the latest releases of the apps are checked at
+	RateLimitKBps uint64        `yaml:"rateLimitKbps"` // Download rate limit, zero means no limit
+	MinFreeDiskMB uint64        `yaml:"minFreeDiskMb"` // Free disk space kept for the sessions, the prefetch stops below it
+	MaxCacheMB    uint64        `yaml:"maxCacheMb"`    // Size of the installed releases, the least recently used are evicted above it, zero means no limit
+}
+
+// DirsConfig is the working directories configuration, the paths are relative to the working directory
+type DirsConfig struct {
+	Temp     string `yaml:"temp"`
+	Download string `yaml:"download"` // Relative to the temp directory
+	Apps     string `yaml:"apps"`
+	Sessions string `yaml:"sessions"` // Relative to the temp directory
+	Outbox   string `yaml:"outbox"`   // Relative to the temp directory
+}
+
+// ClickHouse is the ClickHouse connection configuration
+type ClickHouse struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+	Migrate  bool   `yaml:"migrate"` // Apply the launcher schema migrations at startup
+}
+
+// Default returns the default launcher configuration
+func Default() *Launcher {
+	return &Launcher{
+		Environment: "test",
+		LogLevel:    "info",
+		Api: ApiConfig{
+			Url: Api2Url,
+		},
+		Session: SessionConfig{
+			CheckInterval:          30 * time.Second,
+			MaxCheckInterval:       2 * time.Minute,
+			CheckJitter:            0.2,
+			AssignmentMode:         "poll",
+			LongPollWait:           25 * time.Second,
+			ClaimLease:             2 * time.Minute,
+			StartupTimeout:         5 * time.Minute,
+			ReadinessCheckInterval: 2 * time.Second,
+			WatchdogCheckInterval:  5 * time.Second,
+			HeartbeatTimeout:       30 * time.Second,
+			IdleTimeout:            2 * time.Minute,
+			StopWarning:            time.Minute,
+			StopTimeout:            30 * time.Second,
+		},
+		Instance: InstanceConfig{
+			HeartbeatInterval: 15 * time.Second,
+			RetryInterval:     time.Second,
+			DrainTimeout:      30 * time.Minute,
+		},
+		App: AppConfig{
+			PixelStreamingIP:   "127.0.0.1",
+			PixelStreamingPort: 8888,
+			ResX:               1920,
+			ResY:               1080,
+			SampleInterval:     15 * time.Second,
+		},
+		Control: ControlConfig{
+			Address:         "127.0.0.1:8080",
+			MaxClockSkew:    5 * time.Minute,
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Dirs: DirsConfig{
+			Temp:     TempDir,
+			Download: DownloadDir,
+			Apps:     AppDir,
+			Sessions: SessionDir,
+			Outbox:   OutboxDir,
+		},
+		ClickHouse: ClickHouse{
+			Port: 9000,
+		},
+		Telemetry: Telemetry{
+			Sink:          "clickhouse",
+			File:          filepath.Join(TempDir, "telemetry", "events.jsonl"),
+			QueueSize:     10000,
+			BatchSize:     500,
+			FlushInterval: 5 * time.Second,
+			WriteTimeout:  10 * time.Second,
+			SpillDir:      filepath.Join(TempDir, OutboxDir, "telemetry"),
+			MaxSpillMB:    100,
+		},
+		Prefetch: PrefetchConfig{
+			Enabled:       false,
+			MaxApps:       3,
+			Interval:      30 * time.Minute,
+			RateLimitKBps: 10 * 1024,
+			MinFreeDiskMB: 20 * 1024,
+			MaxCacheMB:    50 * 1024,
+		},
+	}
+}
+
+// Source is where the launcher configuration is loaded from, it is kept to reload the configuration
+type Source struct {
+	Path      string // Configuration file, optional
+	overrides []func(c *Launcher)
+}
+
+// Load builds the launcher configuration from the command line arguments, the configuration file and the environment.
+// The configuration file is set with the -config flag or the LAUNCHER_CONFIG env. The remaining positional arguments are returned.
+func Load(name string, args []string) (*Launcher, *Source, []string, error) {
+	var (
+		source Source
+		flags  = Default()
+		fs     = flag.NewFlagSet(name, flag.ContinueOnError)
+	)
+
+	fs.StringVar(&source.Path, "config", os.Getenv("LAUNCHER_CONFIG"), "Path to the YAML or JSON configuration file")
+	fs.StringVar(&flags.Environment, "env", "", "Environment: dev, test or prod")
+	fs.StringVar(&flags.InstanceId, "instance-id", "", "Instance id")
+	fs.StringVar(&flags.LogLevel, "log-level", "", "Log level")
+	fs.StringVar(&flags.HooksFile, "hooks", "", "Path to the session hooks configuration file")
+	fs.StringVar(&flags.Api.Url, "api-url", "", "API root URL")
+	fs.StringVar(&flags.Control.Address, "control-addr", "", "Control server listen address")
+	fs.StringVar(&flags.LocalRelease, "local-release", "", "Local release directory or zip archive to run without the API")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Only the explicitly set flags override the file and the environment
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "env":
+			source.overrides = append(source.overrides, func(c *Launcher) { c.Environment = flags.Environment })
+		case "instance-id":
+			source.overrides = append(source.overrides, func(c *Launcher) { c.InstanceId = flags.InstanceId })
+		case "log-level":
+			source.overrides = append(source.overrides, func(c *Launcher) { c.LogLevel = flags.LogLevel })
+		case "hooks":
+			source.overrides = append(source.overrides, func(c *Launcher) { c.HooksFile = flags.HooksFile })
+		case "api-url":
+			source.overrides = append(source.overrides, func(c *Launcher) { c.Api.Url = flags.Api.Url })
+		case "control-addr":
+			source.overrides = append(source.overrides, func(c *Launcher) { c.Control.Address = flags.Control.Address })
+		case "local-release":
+			source.overrides = append(source.overrides, func(c *Launcher) { c.LocalRelease = flags.LocalRelease })
+		}
+	})
+
+	c, err := source.Load()
+	if err != nil {
+		return nil, &source, nil, err
+	}
+
+	return c, &source, fs.Args(), nil
+}
+
+// Load builds the launcher configuration from the defaults, the configuration file, the environment and the flags
+func (s *Source) Load() (*Launcher, error) {
+	c := Default()
+
+	if s.Path != "" {
+		if err := c.loadFile(s.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	for _, override := range s.overrides {
+		override(c)
+	}
+
+	return c, nil
+}
+
+// loadFile overrides the configuration with the values of the YAML or JSON file
+func (c *Launcher) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	// JSON is a subset of YAML so the same decoder reads both formats
+	err = yaml.Unmarshal(b, c)
+	if err != nil {
+		return fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadEnv overrides the configuration with the set environment variables
+func (c *Launcher) loadEnv() error {
+	var errs []string
+
+	str := func(name string, v *string) {
+		if s, ok := os.LookupEnv(name); ok && s != "" {
+			*v = s
+		}
+	}
+	integer := func(name string, v *int) {
+		if s, ok := os.LookupEnv(name); ok && s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+				return
+			}
+			*v = n
+		}
+	}
+	unsigned := func(name string, v *uint64) {
+		if s, ok := os.LookupEnv(name); ok && s != "" {
+			n, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+				return
+			}
+			*v = n
+		}
+	}
+	duration := func(name string, v *time.Duration) {
+		if s, ok := os.LookupEnv(name); ok && s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+				return
+			}
+			*v = d
+		}
+	}
+
+	str("LAUNCHER_ENV", &c.Environment)
+	str("INSTANCE_ID", &c.InstanceId)
+	str("LOG_LEVEL", &c.LogLevel)
+	str("HOOKS_CONFIG", &c.HooksFile)
+	str("LOCAL_RELEASE", &c.LocalRelease)
+
+	str("VE_API2_ROOT_URL", &c.Api.Url)
+	str("USER_EMAIL", &c.Api.Email)
+	str("USER_PASSWORD", &c.Api.Password)
+
+	duration("SESSION_CHECK_INTERVAL", &c.Session.CheckInterval)
+	str("SESSION_ASSIGNMENT_MODE", &c.Session.AssignmentMode)
+	duration("SESSION_LONG_POLL_WAIT", &c.Session.LongPollWait)
+	duration("SESSION_CLAIM_LEASE", &c.Session.ClaimLease)
+	duration("SESSION_STARTUP_TIMEOUT", &c.Session.StartupTimeout)
+	duration("SESSION_MAX_DURATION", &c.Session.MaxDuration)
+	duration("SESSION_IDLE_TIMEOUT", &c.Session.IdleTimeout)
+	duration("SESSION_STOP_WARNING", &c.Session.StopWarning)
+	duration("SESSION_STOP_TIMEOUT", &c.Session.StopTimeout)
+
+	str("INSTANCE_TYPE", &c.Instance.Type)
+	str("INSTANCE_REGION", &c.Instance.Region)
+	str("INSTANCE_GPU_CLASS", &c.Instance.GpuClass)
+	duration("INSTANCE_HEARTBEAT_INTERVAL", &c.Instance.HeartbeatInterval)
+	duration("INSTANCE_DRAIN_TIMEOUT", &c.Instance.DrainTimeout)
+
+	str("PIXEL_STREAMING_IP", &c.App.PixelStreamingIP)
+	integer("PIXEL_STREAMING_PORT", &c.App.PixelStreamingPort)
+	integer("APP_RES_X", &c.App.ResX)
+	integer("APP_RES_Y", &c.App.ResY)
+	str("APP_USER", &c.App.User)
+	str("APP_GROUP", &c.App.Group)
+	if s := os.Getenv("APP_ENV_ALLOWLIST"); s != "" {
+		c.App.EnvAllowlist = strings.Split(s, ",")
+	}
+	unsigned("SESSION_MEMORY_LIMIT_MB", &c.App.MemoryLimitMB)
+	unsigned("SESSION_CPU_WEIGHT", &c.App.CPUWeight)
+	unsigned("SESSION_OPEN_FILES_LIMIT", &c.App.OpenFilesLimit)
+
+	str("CONTROL_ADDR", &c.Control.Address)
+	str("CONTROL_SECRET", &c.Control.Secret)
+
+	str("CLICKHOUSE_HOST", &c.ClickHouse.Host)
+	integer("CLICKHOUSE_PORT", &c.ClickHouse.Port)
+	str("CLICKHOUSE_USER", &c.ClickHouse.User)
+	str("CLICKHOUSE_PASS", &c.ClickHouse.Password)
+	str("CLICKHOUSE_NAME", &c.ClickHouse.Database)
+	if s := os.Getenv("CLICKHOUSE_MIGRATE"); s != "" {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("CLICKHOUSE_MIGRATE: %s", err.Error()))
+		} else {
+			c.ClickHouse.Migrate = v
+		}
+	}
+
+	if s := os.Getenv("PREFETCH_ENABLED"); s != "" {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("PREFETCH_ENABLED: %s", err.Error()))
+		} else {
+			c.Prefetch.Enabled = v
+		}
+	}
+	if s := os.Getenv("PREFETCH_APPS"); s != "" {
+		c.Prefetch.Apps = strings.Split(s, ",")
+	}
+	unsigned("PREFETCH_RATE_LIMIT_KBPS", &c.Prefetch.RateLimitKBps)
+	unsigned("PREFETCH_MAX_CACHE_MB", &c.Prefetch.MaxCacheMB)
+
+	str("TELEMETRY_SINK", &c.Telemetry.Sink)
+	str("TELEMETRY_FILE", &c.Telemetry.File)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid env: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Validate checks the configuration values and returns all the found problems
+func (c *Launcher) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	switch strings.ToLower(c.Environment) {
+	case "debug", "dev", "development", "test", "prod", "production", "shipping":
+	default:
+		errs = append(errs, fmt.Sprintf("environment: unknown %q", c.Environment))
+	}
+
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Sprintf("logLevel: %s", err.Error()))
+	}
+
+	if u, err := url.Parse(c.Api.Url); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, fmt.Sprintf("api.url: invalid %q", c.Api.Url))
+	}
+
+	check(c.Session.CheckInterval > 0, "session.checkInterval: must be positive")
+	check(c.Session.MaxCheckInterval >= c.Session.CheckInterval, "session.maxCheckInterval: must not be less than session.checkInterval")
+	check(c.Session.CheckJitter >= 0 && c.Session.CheckJitter < 1, "session.checkJitter: must be in range [0, 1)")
+	switch c.Session.AssignmentMode {
+	case "poll", "websocket":
+	case "longpoll":
+		check(c.Session.LongPollWait > 0, "session.longPollWait: must be positive")
+	default:
+		errs = append(errs, fmt.Sprintf("session.assignmentMode: unknown %q", c.Session.AssignmentMode))
+	}
+	check(c.Session.ClaimLease >= 3*time.Second, "session.claimLease: must be at least 3s")
+	check(c.Session.StartupTimeout > 0, "session.startupTimeout: must be positive")
+	check(c.Session.ReadinessCheckInterval > 0, "session.readinessCheckInterval: must be positive")
+	check(c.Session.WatchdogCheckInterval > 0, "session.watchdogCheckInterval: must be positive")
+	check(c.Session.HeartbeatTimeout > 0, "session.heartbeatTimeout: must be positive")
+	check(c.Session.MaxDuration >= 0, "session.maxDuration: must not be negative")
+	check(c.Session.IdleTimeout >= 0, "session.idleTimeout: must not be negative")
+	check(c.Session.StopWarning >= 0, "session.stopWarning: must not be negative")
+	check(c.Session.StopTimeout > 0, "session.stopTimeout: must be positive")
+
+	check(c.Instance.HeartbeatInterval > 0, "instance.heartbeatInterval: must be positive")
+	check(c.Instance.RetryInterval > 0, "instance.retryInterval: must be positive")
+	check(c.Instance.DrainTimeout >= 0, "instance.drainTimeout: must not be negative")
+
+	check(net.ParseIP(c.App.PixelStreamingIP) != nil, "app.pixelStreamingIp: invalid %q", c.App.PixelStreamingIP)
+	check(c.App.PixelStreamingPort > 0 && c.App.PixelStreamingPort < 65536, "app.pixelStreamingPort: out of range")
+	check(c.App.ResX > 0 && c.App.ResY > 0, "app.resX, app.resY: must be positive")
+	check(c.App.SampleInterval > 0, "app.sampleInterval: must be positive")
+
+	if _, _, err := net.SplitHostPort(c.Control.Address); err != nil {
+		errs = append(errs, fmt.Sprintf("control.address: %s", err.Error()))
+	}
+	check(c.Control.MaxClockSkew > 0, "control.maxClockSkew: must be positive")
+	check(c.Control.ReadTimeout >= 0, "control.readTimeout: must not be negative")
+	check(c.Control.WriteTimeout >= 0, "control.writeTimeout: must not be negative")
+	check(c.Control.IdleTimeout >= 0, "control.idleTimeout: must not be negative")
+	check(c.Control.ShutdownTimeout > 0, "control.shutdownTimeout: must be positive")
+
+	for name, dir := range map[string]string{"temp": c.Dirs.Temp, "download": c.Dirs.Download, "apps": c.Dirs.Apps, "sessions": c.Dirs.Sessions, "outbox": c.Dirs.Outbox} {
+		check(dir != "" && !filepath.IsAbs(dir), "dirs.%s: must be a relative path", name)
+	}
+
+	if c.ClickHouse.Host != "" {
+		check(c.ClickHouse.Port > 0 && c.ClickHouse.Port < 65536, "clickhouse.port: out of range")
+	}
+
+	switch c.Telemetry.Sink {
+	case "clickhouse", "none":
+	case "file":
+		check(c.Telemetry.File != "", "telemetry.file: must be set for the file sink")
+	default:
+		errs = append(errs, fmt.Sprintf("telemetry.sink: unknown %q", c.Telemetry.Sink))
+	}
+	check(c.Telemetry.QueueSize > 0, "telemetry.queueSize: must be positive")
+	check(c.Telemetry.BatchSize > 0, "telemetry.batchSize: must be positive")
+	check(c.Telemetry.FlushInterval > 0, "telemetry.flushInterval: must be positive")
+	check(c.Telemetry.WriteTimeout > 0, "telemetry.writeTimeout: must be positive")
+	check(c.Telemetry.MaxSpillMB >= 0, "telemetry.maxSpillMb: must not be negative")
+
+	if c.Prefetch.Enabled {
+		for _, id := range c.Prefetch.Apps {
+			_, err := uuid.FromString(id)
+			check(err == nil, "prefetch.apps: invalid app id %q", id)
+		}
+		check(c.Prefetch.MaxApps > 0, "prefetch.maxApps: must be positive")
+		check(c.Prefetch.Interval > 0, "prefetch.interval: must be positive")
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Merge returns the next configuration with the settings that can not be changed without restarting the launcher kept
+// at their current values, and the names of such changed settings. The app and instance settings are kept at their
+// current values as well and their changed names are returned separately, they are applied with MergeSession once the
+// app starts next time.
+func Merge(current *Launcher, next *Launcher) (*Launcher, []string, []string) {
+	m := *next
+	var pending, session []string
+
+	if !reflect.DeepEqual(m.App, current.App) {
+		session = append(session, "app")
+		m.App = current.App
+	}
+	if m.Instance != current.Instance {
+		session = append(session, "instance")
+		m.Instance = current.Instance
+	}
+
+	if m.Environment != current.Environment {
+		pending = append(pending, "environment")
+		m.Environment = current.Environment
+	}
+	if m.InstanceId != current.InstanceId {
+		pending = append(pending, "instanceId")
+		m.InstanceId = current.InstanceId
+	}
+	if m.HooksFile != current.HooksFile {
+		pending = append(pending, "hooksFile")
+		m.HooksFile = current.HooksFile
+	}
+	if m.LocalRelease != current.LocalRelease {
+		pending = append(pending, "localRelease")
+		m.LocalRelease = current.LocalRelease
+	}
+	if m.Api != current.Api {
+		pending = append(pending, "api")
+		m.Api = current.Api
+	}
+	if m.Control != current.Control {
+		pending = append(pending, "control")
+		m.Control = current.Control
+	}
+	if m.Dirs != current.Dirs {
+		pending = append(pending, "dirs")
+		m.Dirs = current.Dirs
+	}
+	if m.ClickHouse != current.ClickHouse {
+		pending = append(pending, "clickhouse")
+		m.ClickHouse = current.ClickHouse
+	}
+	if m.Telemetry != current.Telemetry {
+		pending = append(pending, "telemetry")
+		m.Telemetry = current.Telemetry
+	}
+
+	return &m, pending, session
+}
+
+// MergeSession returns the current configuration with the app and instance settings of the next configuration, which
+// are applied once the app starts
+func MergeSession(current *Launcher, next *Launcher) *Launcher {
+	m := *current
+	m.App = next.App
+	m.Instance = next.Instance
+
+	return &m
+}
+
+// Redacted returns a copy of the configuration with the secrets replaced
+func (c *Launcher) Redacted() *Launcher {
+	r := *c
+	r.App.EnvAllowlist = append([]string(nil), c.App.EnvAllowlist...)
+	r.Prefetch.Apps = append([]string(nil), c.Prefetch.Apps...)
+
+	for _, v := range []*string{&r.Api.Password, &r.Control.Secret, &r.ClickHouse.Password} {
+		if *v != "" {
+			*v = redacted
+		}
+	}
+
+	return &r
+}
+
+// Print writes the configuration with the secrets redacted as YAML
+func (c *Launcher) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	err := enc.Encode(c.Redacted())
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	return enc.Close()
+}
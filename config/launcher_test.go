@@ -0,0 +1,96 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadApiUrl(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "launcher.yaml")
+	err := os.WriteFile(path, []byte("api:\n  url: http://file.invalid/v2\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write config: %s", err.Error())
+	}
+
+	tests := []struct {
+		name string
+		env  string
+		args []string
+		want string
+	}{
+		{name: "default", want: Api2Url},
+		{name: "file", args: []string{"-config", path}, want: "http://file.invalid/v2"},
+		{name: "env", env: srv.URL, args: []string{"-config", path}, want: srv.URL},
+		{name: "flag", env: "http://env.invalid/v2", args: []string{"-config", path, "-api-url", srv.URL}, want: srv.URL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LAUNCHER_CONFIG", "")
+			t.Setenv("VE_API2_ROOT_URL", tt.env)
+
+			c, _, _, err := Load("launcher", tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if c.Api.Url != tt.want {
+				t.Errorf("got api url %q, want %q", c.Api.Url, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateApiUrl(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	c := Default()
+	c.Api.Url = srv.URL
+	if err := c.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	c.Api.Url = "127.0.0.1/v2"
+	if err := c.Validate(); err == nil {
+		t.Errorf("expected error for the api url without the scheme")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	current := Default()
+
+	next := Default()
+	next.LogLevel = "debug"
+	next.Api.Url = "http://127.0.0.1:3000/v2"
+	next.App.ResX = 1280
+	next.App.User = "app"
+	next.Instance.HeartbeatInterval = current.Instance.HeartbeatInterval * 2
+
+	m, pending, session := Merge(current, next)
+	if m.LogLevel != "debug" {
+		t.Errorf("got log level %q, want the live setting applied", m.LogLevel)
+	}
+	if m.Api != current.Api || len(pending) != 1 || pending[0] != "api" {
+		t.Errorf("got api %+v, pending %v, want the api kept until the restart", m.Api, pending)
+	}
+	if m.App.ResX != current.App.ResX || m.App.User != current.App.User || m.Instance != current.Instance {
+		t.Errorf("got app %+v, instance %+v, want the app and instance settings kept until the next session", m.App, m.Instance)
+	}
+	if len(session) != 2 || session[0] != "app" || session[1] != "instance" {
+		t.Errorf("got next session settings %v, want [app instance]", session)
+	}
+
+	s := MergeSession(m, next)
+	if s.App.ResX != 1280 || s.App.User != "app" || s.Instance != next.Instance {
+		t.Errorf("got app %+v, instance %+v, want the next session settings applied", s.App, s.Instance)
+	}
+	if s.LogLevel != "debug" || s.Api != current.Api {
+		t.Errorf("got log level %q, api %+v, want the other settings unchanged", s.LogLevel, s.Api)
+	}
+}
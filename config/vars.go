@@ -4,5 +4,8 @@ package config
 // LauncherId is the launcher id set during the build process using the -ldflags "-X config.LauncherId=..." flag.
 var LauncherId string
 
+// LauncherVersion is the launcher version set during the build process using the -ldflags "-X config.LauncherVersion=..." flag.
+var LauncherVersion string
+
 // Logging is a flag that indicates whether logging is enabled.
 var Logging string
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// appControlChannel sends line-delimited JSON messages to the app over its standard input, the app reading the standard
+// input receives them in addition to the events relayed by the signalling web server
+type appControlChannel struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+// newAppControlChannel creates a new appControlChannel writing to the app standard input pipe
+func newAppControlChannel(w io.WriteCloser) *appControlChannel {
+	return &appControlChannel{w: w}
+}
+
+// Send sends the message of the given type with the optional payload to the app
+func (c *appControlChannel) Send(messageType string, payload map[string]interface{}) error {
+	message := map[string]interface{}{
+		"type": messageType,
+	}
+	for k, v := range payload {
+		message[k] = v
+	}
+
+	b, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal control message: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err = c.w.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write control message: %w", err)
+	}
+
+	return nil
+}
@@ -6,35 +6,38 @@ import (
 	"fmt"
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
-	"os"
-	"strconv"
+	"github.com/sirupsen/logrus"
 	"time"
+	"veverse-pixel-streaming-launcher/config"
 )
 
 var Clickhouse driver.Conn
 
-func SetupClickhouse(ctx context.Context) (context.Context, error) {
-	clickhouseHost := os.Getenv("CLICKHOUSE_HOST")
-	clickhousePort := os.Getenv("CLICKHOUSE_PORT")
-	clickhouseUser := os.Getenv("CLICKHOUSE_USER")
-	clickhousePass := os.Getenv("CLICKHOUSE_PASS")
-	clickhouseName := os.Getenv("CLICKHOUSE_NAME")
+// defaultPort is the ClickHouse native protocol port used if the port is not set
+const defaultPort = 9000
 
-	clickhousePortNum, err := strconv.Atoi(clickhousePort)
-	if err != nil {
-		return ctx, fmt.Errorf("failed to convert clickhouse port to int: %w", err)
+// SetupClickhouse opens the ClickHouse connection, the connection is established lazily by the first query so an
+// unreachable database does not block the launcher startup
+func SetupClickhouse(ctx context.Context, c config.ClickHouse) (context.Context, error) {
+	if c.Host == "" {
+		return ctx, fmt.Errorf("clickhouse host is not set")
+	}
+
+	port := c.Port
+	if port == 0 {
+		port = defaultPort
 	}
 
 	conn, err := clickhouse.Open(&clickhouse.Options{
-		Addr: []string{fmt.Sprintf("%s:%d", clickhouseHost, clickhousePortNum)},
+		Addr: []string{fmt.Sprintf("%s:%d", c.Host, port)},
 		Auth: clickhouse.Auth{
-			Database: clickhouseName,
-			Username: clickhouseUser,
-			Password: clickhousePass,
+			Database: c.Database,
+			Username: c.User,
+			Password: c.Password,
 		},
-		Debug: true,
+		Debug: logrus.IsLevelEnabled(logrus.TraceLevel),
 		Debugf: func(format string, v ...any) {
-			fmt.Printf(format, v)
+			logrus.Tracef(format, v...)
 		},
 		Settings: clickhouse.Settings{
 			"max_execution_time": 60,
@@ -57,5 +60,5 @@ func SetupClickhouse(ctx context.Context) (context.Context, error) {
 
 	ctx = context.WithValue(ctx, vContext.Clickhouse, conn)
 
-	return ctx, conn.Ping(context.Background())
+	return ctx, nil
 }
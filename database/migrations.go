@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// migration is a launcher schema change, applied once in the version order
+type migration struct {
+	version uint32
+	query   string
+}
+
+// migrations are the launcher schema changes, new migrations are appended with the next version
+var migrations = []migration{
+	{1, `CREATE TABLE IF NOT EXISTS launcher_events (
+	time DateTime64(3),
+	instance_id String,
+	type LowCardinality(String),
+	data String
+) ENGINE = MergeTree
+ORDER BY (instance_id, time)`},
+	{2, `CREATE TABLE IF NOT EXISTS launcher_sessions (
+	time DateTime64(3),
+	session_id String,
+	instance_id String,
+	app_id String,
+	release_version String,
+	time_to_claim Float64,
+	download_time Float64,
+	download_bytes Int64,
+	extract_time Float64,
+	time_to_ready Float64,
+	duration Float64,
+	restarts Int64,
+	exit_code Int64,
+	status LowCardinality(String),
+	close_reason LowCardinality(String)
+) ENGINE = MergeTree
+ORDER BY (app_id, time)`},
+	// The deduplication window lets the replayed telemetry batches skip the inserts already written
+	{3, `ALTER TABLE launcher_events MODIFY SETTING non_replicated_deduplication_window = 1000`},
+	{4, `ALTER TABLE launcher_sessions MODIFY SETTING non_replicated_deduplication_window = 1000`},
+}
+
+// Migrate applies the launcher schema migrations not applied yet and returns the number of the applied migrations
+func Migrate(ctx context.Context, conn driver.Conn) (int, error) {
+	err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS launcher_schema_migrations (
+	version UInt32,
+	applied_at DateTime
+) ENGINE = MergeTree
+ORDER BY version`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var current uint32
+	err = conn.QueryRow(ctx, "SELECT max(version) FROM launcher_schema_migrations").Scan(&current)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		err = conn.Exec(ctx, m.query)
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d: %w", m.version, err)
+		}
+
+		err = conn.Exec(ctx, "INSERT INTO launcher_schema_migrations (version, applied_at) VALUES (?, now())", m.version)
+		if err != nil {
+			return applied, fmt.Errorf("failed to record migration %d: %w", m.version, err)
+		}
+
+		applied++
+	}
+
+	return applied, nil
+}
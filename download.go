@@ -9,8 +9,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
-	"veverse-pixel-streaming-launcher/config"
+	"time"
 	"veverse-pixel-streaming-launcher/http"
+	"veverse-pixel-streaming-launcher/metrics"
 	"veverse-pixel-streaming-launcher/utils"
 	"veverse-pixel-streaming-launcher/version"
 )
@@ -39,26 +40,50 @@ func installAppReleaseArchive(ctx context.Context, appId uuid.UUID, release sm.R
 	}
 	logrus.Debugf("working directory: %s", wd)
 
-	tempDownloadPath := filepath.Join(wd, config.TempDir, config.DownloadDir, appId.String(), release.Id.String()+"-"+release.Version)
+	tempDownloadPath := filepath.Join(wd, cfg().Dirs.Temp, cfg().Dirs.Download, appId.String(), release.Id.String()+"-"+release.Version)
 	logrus.Debugf("temp download path: %s", tempDownloadPath)
-	appInstallationPath := filepath.Join(wd, config.AppDir, appId.String(), release.Id.String()+"-"+release.Version)
+	appInstallationPath := filepath.Join(wd, cfg().Dirs.Apps, appId.String(), release.Id.String()+"-"+release.Version)
 	logrus.Debugf("app installation path: %s", appInstallationPath)
 
+	publish := publishProgress(eventDownloadProgress, map[string]interface{}{
+		"appId":     appId,
+		"releaseId": release.Id,
+		"fileId":    archive.Id,
+	})
+	startedAt := time.Now()
 	counter := http.NewDownloadProgressTracker((uint64)(*archive.Size), func(progress uint64, total uint64) {
+		if isPrefetch(ctx) {
+			throttleDownload(ctx, startedAt, progress)
+			return
+		}
 		logrus.Printf("downloading file: %d/%d", progress, total)
+		publish(progress, total)
 	})
 	logrus.Debugf("downloading file to %s...", tempDownloadPath)
 	err = http.DownloadFile(ctx, tempDownloadPath, archive.Url, counter)
+	recordDownload(ctx, appId, release, counter.Current, time.Since(startedAt))
 	if err != nil {
 		return fmt.Errorf("failed to download file: %w", err)
 	}
 	logrus.Debugf("downloaded file to %s", tempDownloadPath)
 
 	logrus.Debugf("extracting archive to %s...", appInstallationPath)
-	err = utils.ExtractArchive(tempDownloadPath, appInstallationPath)
+	startedAt = time.Now()
+	extractProgress := publishProgress(eventExtractProgress, map[string]interface{}{
+		"appId":     appId,
+		"releaseId": release.Id,
+	})
+	if isPrefetch(ctx) {
+		extractProgress = nil
+	}
+	err = utils.ExtractArchive(ctx, tempDownloadPath, appInstallationPath, extractProgress)
 	if err != nil {
 		return fmt.Errorf("failed to extract archive: %w", err)
 	}
+	metrics.ExtractDuration.Set(time.Since(startedAt).Seconds(), appId.String(), release.Version)
+	if !isPrefetch(ctx) {
+		analytics.RecordExtract(time.Since(startedAt))
+	}
 	logrus.Debugf("extracted archive to %s", appInstallationPath)
 
 	logrus.Debugf("parsing release version: %s...", release.Version)
@@ -75,6 +100,12 @@ func installAppReleaseArchive(ctx context.Context, appId uuid.UUID, release sm.R
 	}
 	logrus.Debugf("wrote version to %s", appInstallationPath)
 
+	// The manifest is written last, the release without it is not reused
+	err = writeReleaseManifest(appInstallationPath, &release)
+	if err != nil {
+		return err
+	}
+
 	logrus.Debugf("removing temporary download directory %s...", tempDownloadPath)
 	err = os.RemoveAll(tempDownloadPath)
 	if err != nil {
@@ -105,8 +136,8 @@ func installAppRelease(ctx context.Context, appId uuid.UUID, release sm.ReleaseV
 		return fmt.Errorf("failed to get working directory: %w", err)
 	}
 
-	tempDownloadPath := filepath.Join(wd, config.TempDir, config.DownloadDir, appId.String(), release.Id.String()+"-"+release.Version)
-	appInstallationPath := filepath.Join(wd, config.AppDir, appId.String(), release.Id.String()+"-"+release.Version)
+	tempDownloadPath := filepath.Join(wd, cfg().Dirs.Temp, cfg().Dirs.Download, appId.String(), release.Id.String()+"-"+release.Version)
+	appInstallationPath := filepath.Join(wd, cfg().Dirs.Apps, appId.String(), release.Id.String()+"-"+release.Version)
 
 	var totalProgress uint64 = 0
 	var totalSize uint64 = 0
@@ -118,26 +149,37 @@ func installAppRelease(ctx context.Context, appId uuid.UUID, release sm.ReleaseV
 
 	logrus.Debugf("total size: %d", totalSize)
 
+	startedAt := time.Now()
+	var downloaded uint64
 	for _, file := range files {
+		publish := publishProgress(eventDownloadProgress, map[string]interface{}{
+			"appId":     appId,
+			"releaseId": release.Id,
+			"fileId":    file.Id,
+		})
 		counter := http.NewDownloadProgressTracker(totalSize, func(progress uint64, total uint64) {
 			// accumulate progress for all files and report it to the frontend as total progress
 			totalProgress += progress
+			publish(progress, total)
 		})
 		// download next file
 		err = http.DownloadFile(ctx, tempDownloadPath, file.Url, counter)
+		downloaded += counter.Current
 		if err != nil {
-			logrus.Errorf("failed to download file: %s", err.Error())
+			recordDownload(ctx, appId, release, downloaded, time.Since(startedAt))
+			return fmt.Errorf("failed to download file %s: %w", file.Id, err)
 		}
 	}
+	recordDownload(ctx, appId, release, downloaded, time.Since(startedAt))
 
+	// The release missing any of its files is not installed, so it is not reused as a complete one
 	for _, file := range files {
 		if file.OriginalPath == nil {
-			logrus.Errorf("file %s has no original path", file.Id)
-			continue
+			return fmt.Errorf("file %s has no original path", file.Id)
 		}
 		err = os.Rename(filepath.Join(tempDownloadPath, *file.OriginalPath), filepath.Join(appInstallationPath, *file.OriginalPath))
 		if err != nil {
-			logrus.Errorf("failed to move file: %s", err.Error())
+			return fmt.Errorf("failed to move file %s: %w", file.Id, err)
 		}
 	}
 
@@ -151,6 +193,12 @@ func installAppRelease(ctx context.Context, appId uuid.UUID, release sm.ReleaseV
 		return fmt.Errorf("failed to write version: %w", err)
 	}
 
+	// The manifest is written last, the release without it is not reused
+	err = writeReleaseManifest(appInstallationPath, &release)
+	if err != nil {
+		return err
+	}
+
 	err = os.RemoveAll(tempDownloadPath)
 	if err != nil {
 		return fmt.Errorf("failed to remove temporary download directory: %w", err)
@@ -158,3 +206,15 @@ func installAppRelease(ctx context.Context, appId uuid.UUID, release sm.ReleaseV
 
 	return nil
 }
+
+// recordDownload records the release download metrics, the prefetch is not a part of the session analytics
+func recordDownload(ctx context.Context, appId uuid.UUID, release sm.ReleaseV2, bytes uint64, duration time.Duration) {
+	if !isPrefetch(ctx) {
+		analytics.RecordDownload(bytes, duration)
+	}
+	metrics.DownloadBytes.Add(float64(bytes), appId.String(), release.Version)
+	metrics.DownloadDuration.Set(duration.Seconds(), appId.String(), release.Version)
+	if duration > 0 {
+		metrics.DownloadThroughput.Set(float64(bytes)/duration.Seconds(), appId.String(), release.Version)
+	}
+}
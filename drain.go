@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// closeReasonDrain is the session close reason when the session has not finished before the drain deadline
+const closeReasonDrain = "instance-drain"
+
+// drainPollTime is the interval the drained session is checked at
+const drainPollTime = time.Second
+
+// drainState is the drain state reported by the status endpoint
+type drainState struct {
+	Draining        bool       `json:"draining"`
+	StartedAt       *time.Time `json:"startedAt,omitempty"`
+	Deadline        *time.Time `json:"deadline,omitempty"` // The session is closed once the deadline has passed, nil means no deadline
+	SafeToTerminate bool       `json:"safeToTerminate"`
+}
+
+// drainController stops the instance from taking new sessions before the planned termination, the current session is
+// closed if it has not finished before the deadline
+type drainController struct {
+	sessions  sessionController
+	heartbeat *instanceHeartbeat
+	started   chan struct{} // Closed once the drain has started
+	mu        sync.Mutex
+	startedAt time.Time
+	deadline  time.Time
+}
+
+// newDrainController creates a new drainController
+func newDrainController(sessions sessionController, heartbeat *instanceHeartbeat) *drainController {
+	return &drainController{
+		sessions:  sessions,
+		heartbeat: heartbeat,
+		started:   make(chan struct{}),
+	}
+}
+
+// Drain starts draining the instance, the session is closed after the timeout, zero timeout waits for the session to
+// finish. Draining the already draining instance only shortens the deadline.
+func (d *drainController) Drain(ctx context.Context, timeout time.Duration) drainState {
+	d.mu.Lock()
+	started := d.startedAt.IsZero()
+	if started {
+		d.startedAt = time.Now()
+	}
+	if timeout > 0 {
+		deadline := time.Now().Add(timeout)
+		if d.deadline.IsZero() || deadline.Before(d.deadline) {
+			d.deadline = deadline
+		}
+	}
+	d.mu.Unlock()
+
+	if started {
+		logrus.Infof("draining the instance, no new sessions are taken")
+		close(d.started)
+		d.heartbeat.SetDraining()
+		go d.run(ctx)
+	}
+
+	return d.State()
+}
+
+// Draining checks if the instance is draining
+func (d *drainController) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return !d.startedAt.IsZero()
+}
+
+// Started is closed once the drain has started
+func (d *drainController) Started() <-chan struct{} {
+	return d.started
+}
+
+// State returns the drain state, nil-safe
+func (d *drainController) State() drainState {
+	if d == nil {
+		return drainState{}
+	}
+
+	d.mu.Lock()
+	startedAt, deadline := d.startedAt, d.deadline
+	d.mu.Unlock()
+
+	var state drainState
+	if startedAt.IsZero() {
+		return state
+	}
+
+	state.Draining = true
+	state.StartedAt = &startedAt
+	if !deadline.IsZero() {
+		state.Deadline = &deadline
+	}
+	state.SafeToTerminate = sessionEnded(d.sessions.Status())
+
+	return state
+}
+
+// run closes the session once the deadline has passed and reports the instance safe to terminate once the session has ended
+func (d *drainController) run(ctx context.Context) {
+	ticker := time.NewTicker(drainPollTime)
+	defer ticker.Stop()
+
+	var closing bool
+	for {
+		status := d.sessions.Status()
+		if sessionEnded(status) {
+			logrus.Infof("the instance has been drained and is safe to terminate")
+			d.heartbeat.notify()
+			return
+		}
+
+		d.mu.Lock()
+		deadline := d.deadline
+		d.mu.Unlock()
+
+		if !closing && !deadline.IsZero() && time.Now().After(deadline) {
+			closing = true
+			logrus.Infof("closing the session %s at the drain deadline", status.SessionId)
+			err := d.sessions.Close(ctx, closeReasonDrain)
+			if err != nil {
+				logrus.Errorf("failed to close the session: %s\n", err.Error())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sessionEnded checks that the instance has no session or the session has ended and its app has exited
+func sessionEnded(status launcherStatus) bool {
+	if status.Pid != 0 {
+		return false
+	}
+
+	return status.SessionId == nil || status.Phase == phaseClosed
+}
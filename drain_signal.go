@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchDrainSignal drains the instance on SIGUSR1 until the context is cancelled
+func watchDrainSignal(ctx context.Context, drain *drainController) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			drain.Drain(ctx, cfg().Instance.DrainTimeout)
+		}
+	}
+}
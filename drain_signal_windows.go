@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "context"
+
+// watchDrainSignal does nothing, there is no SIGUSR1 on Windows and the instance is drained through the control API only
+func watchDrainSignal(_ context.Context, _ *drainController) {}
@@ -0,0 +1,100 @@
+// Package events provides the broker of the launcher and session events streamed to the control API clients.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is the number of events buffered for each subscriber, slow subscribers are dropped once it is full
+const subscriberBuffer = 64
+
+// Event is a single launcher or session event.
+type Event struct {
+	Id   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Broker publishes the events to the subscribers and keeps the recent events history, so the reconnecting subscribers
+// can resume from the last event they have received.
+type Broker struct {
+	mu          sync.Mutex
+	lastId      uint64
+	history     []Event
+	historySize int
+	subscribers map[chan Event]struct{}
+}
+
+// NewBroker creates a new Broker keeping up to historySize recent events.
+func NewBroker(historySize int) *Broker {
+	return &Broker{
+		historySize: historySize,
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+// Publish publishes the event of the given type with the data to all subscribers.
+func (b *Broker) Publish(eventType string, data interface{}) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastId++
+	e := Event{
+		Id:   b.lastId,
+		Type: eventType,
+		Time: time.Now(),
+		Data: data,
+	}
+
+	b.history = append(b.history, e)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// The subscriber is too slow, drop it, it will resume from its last event after reconnecting
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe subscribes to the events published after the event with the lastId, zero lastId means no replay. Returns the
+// events from the history the subscriber has missed, the channel of the new events, closed when the subscriber is
+// dropped, and the function to unsubscribe.
+func (b *Broker) Subscribe(lastId uint64) (missed []Event, ch <-chan Event, unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lastId > 0 {
+		for _, e := range b.history {
+			if e.Id > lastId {
+				missed = append(missed, e)
+			}
+		}
+	}
+
+	c := make(chan Event, subscriberBuffer)
+	b.subscribers[c] = struct{}{}
+
+	unsubscribe = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[c]; ok {
+			delete(b.subscribers, c)
+			close(c)
+		}
+	}
+
+	return missed, c, unsubscribe
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"veverse-pixel-streaming-launcher/config"
+	"veverse-pixel-streaming-launcher/system"
+)
+
+// Instance statuses reported by the heartbeat
+const (
+	instanceFree      = "free"
+	instanceBusy      = "busy"
+	instanceDraining  = "draining"
+	instanceUnhealthy = "unhealthy"
+)
+
+// cachedRelease is the app release installed at the instance
+type cachedRelease struct {
+	AppId   string `json:"appId"`
+	Release string `json:"release"`
+}
+
+// instanceHeartbeatData is the instance state reported to the API by the heartbeat
+type instanceHeartbeatData struct {
+	InstanceId      string          `json:"instanceId"`
+	Status          string          `json:"status"`
+	Version         string          `json:"version"`
+	InstanceType    string          `json:"instanceType,omitempty"`
+	Platform        string          `json:"platform"`
+	Region          string          `json:"region,omitempty"`
+	GpuClass        string          `json:"gpuClass,omitempty"`
+	SessionId       *uuid.UUID      `json:"sessionId,omitempty"`
+	AppId           *uuid.UUID      `json:"appId,omitempty"`
+	Phase           string          `json:"phase"`
+	FreeDiskBytes   uint64          `json:"freeDiskBytes"`
+	Load            float64         `json:"load"`
+	Apps            []cachedRelease `json:"apps"`
+	SafeToTerminate bool            `json:"safeToTerminate"` // Set once the draining instance has no running session
+}
+
+// heartbeatState is the state of the last heartbeat reported by the status endpoint
+type heartbeatState struct {
+	Status string    `json:"status"`
+	SentAt time.Time `json:"sentAt,omitempty"` // Last successful heartbeat
+	Error  string    `json:"error,omitempty"`  // Error of the last heartbeat if it has failed
+}
+
+// instanceHeartbeat periodically reports the instance status and state to the API, the status changes are reported at once
+type instanceHeartbeat struct {
+	sessions  sessionController
+	wake      chan struct{}
+	mu        sync.Mutex
+	unhealthy bool
+	draining  bool
+	state     heartbeatState
+}
+
+// newInstanceHeartbeat creates a new instanceHeartbeat of the session controller
+func newInstanceHeartbeat(sessions sessionController) *instanceHeartbeat {
+	return &instanceHeartbeat{
+		sessions: sessions,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// SetUnhealthy reports the instance as unhealthy, e.g. when the control server can not serve the signalling server requests
+func (h *instanceHeartbeat) SetUnhealthy() {
+	h.mu.Lock()
+	h.unhealthy = true
+	h.mu.Unlock()
+
+	h.notify()
+}
+
+// SetDraining reports the instance as draining, the instance does not take new sessions
+func (h *instanceHeartbeat) SetDraining() {
+	h.mu.Lock()
+	h.draining = true
+	h.mu.Unlock()
+
+	h.notify()
+}
+
+// Status returns the current instance status
+func (h *instanceHeartbeat) Status() string {
+	h.mu.Lock()
+	unhealthy, draining := h.unhealthy, h.draining
+	h.mu.Unlock()
+
+	status := h.sessions.Status()
+	switch {
+	case unhealthy:
+		return instanceUnhealthy
+	case draining || status.Phase == phaseClosed:
+		// The launcher does not take a new session after the session has ended
+		return instanceDraining
+	case status.SessionId != nil:
+		return instanceBusy
+	default:
+		return instanceFree
+	}
+}
+
+// State returns the state of the last heartbeat, nil-safe
+func (h *instanceHeartbeat) State() heartbeatState {
+	if h == nil {
+		return heartbeatState{}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.state
+}
+
+// Run sends the heartbeats until the context is cancelled. A failed heartbeat is retried with the growing delay up to
+// the heartbeat interval, the session phase changes are reported without waiting for the next heartbeat.
+func (h *instanceHeartbeat) Run(ctx context.Context) {
+	_, ch, unsubscribe := launcherEvents.Subscribe(0)
+	defer unsubscribe()
+
+	go func() {
+		for e := range ch {
+			if e.Type == eventSessionPhase {
+				h.notify()
+			}
+		}
+	}()
+
+	var retry time.Duration
+	for {
+		wait := cfg().Instance.HeartbeatInterval
+
+		err := h.send(ctx)
+		if err != nil {
+			if retry == 0 {
+				retry = cfg().Instance.RetryInterval
+			} else {
+				retry *= 2
+			}
+			if retry < wait {
+				wait = retry
+			}
+			logrus.Warningf("failed to send instance heartbeat, retrying in %s: %s", wait, err.Error())
+		} else {
+			retry = 0
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-h.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// notify wakes the heartbeat loop to report the status change at once
+func (h *instanceHeartbeat) notify() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// send sends a single heartbeat and records its result
+func (h *instanceHeartbeat) send(ctx context.Context) error {
+	data := h.collect()
+
+	err := SendInstanceHeartbeat(ctx, data)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.state.Status = data.Status
+	if err != nil {
+		h.state.Error = err.Error()
+		return err
+	}
+	h.state.SentAt = time.Now().UTC()
+	h.state.Error = ""
+
+	return nil
+}
+
+// collect gathers the instance state reported by the heartbeat
+func (h *instanceHeartbeat) collect() instanceHeartbeatData {
+	status := h.sessions.Status()
+	caps := collectCapabilities()
+
+	data := instanceHeartbeatData{
+		InstanceId:    instanceId,
+		Status:        h.Status(),
+		Version:       config.LauncherVersion,
+		InstanceType:  caps.InstanceType,
+		Platform:      caps.Platform,
+		Region:        caps.Region,
+		GpuClass:      caps.GpuClass,
+		SessionId:     status.SessionId,
+		AppId:         status.AppId,
+		Phase:         status.Phase,
+		FreeDiskBytes: caps.FreeDiskBytes,
+		Apps:          cachedReleases(),
+	}
+	data.SafeToTerminate = data.Status == instanceDraining && sessionEnded(status)
+	if data.Version == "" {
+		data.Version = "dev"
+	}
+
+	var err error
+	data.Load, err = system.Load()
+	if err != nil {
+		logrus.Debugf("failed to get load average: %s", err.Error())
+	}
+
+	return data
+}
+
+// cachedReleases lists the app releases installed in the apps directory
+func cachedReleases() []cachedRelease {
+	releases := []cachedRelease{}
+
+	apps, err := os.ReadDir(cfg().Dirs.Apps)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Errorf("failed to read apps directory: %s\n", err.Error())
+		}
+		return releases
+	}
+
+	for _, app := range apps {
+		if !app.IsDir() {
+			continue
+		}
+
+		entries, err := os.ReadDir(filepath.Join(cfg().Dirs.Apps, app.Name()))
+		if err != nil {
+			logrus.Errorf("failed to read app directory: %s\n", err.Error())
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				releases = append(releases, cachedRelease{AppId: app.Name(), Release: entry.Name()})
+			}
+		}
+	}
+
+	return releases
+}
@@ -0,0 +1,179 @@
+// Package hooks provides the configurable commands run by the launcher around the session lifecycle.
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Stage is the point of the session lifecycle the hooks are run at.
+type Stage string
+
+// Supported hook stages in the order they are run.
+const (
+	PreInstall  Stage = "preInstall"  // Before the app release is downloaded and installed
+	PreLaunch   Stage = "preLaunch"   // Before the app is started
+	PostExit    Stage = "postExit"    // After the app has exited
+	PostCleanup Stage = "postCleanup" // After the session sandbox has been wiped
+)
+
+// DefaultTimeout is used for the hooks without a configured timeout.
+const DefaultTimeout = time.Duration(5) * time.Minute
+
+// Duration is a time.Duration unmarshalled from a string such as "30s" or "5m".
+type Duration time.Duration
+
+// UnmarshalJSON implements the json.Unmarshaler interface for the Duration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %s: %w", s, err)
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// Hook is a single command run at the lifecycle stage.
+type Hook struct {
+	Name           string   `json:"name"`
+	Command        []string `json:"command"`        // Executable and its arguments
+	Timeout        Duration `json:"timeout"`        // Maximum time the command may run
+	AbortOnFailure bool     `json:"abortOnFailure"` // Abort the session if the command fails
+}
+
+// Config lists the hooks for each stage, hooks of the stage are run in order.
+type Config map[Stage][]Hook
+
+// Env is the session information passed to the hooks as environment variables.
+type Env struct {
+	SessionId  string
+	AppId      string
+	ReleaseDir string
+	ExitCode   *int // Set for the post-exit hooks only
+}
+
+// AbortError is returned when a hook configured to abort the session has failed.
+type AbortError struct {
+	Stage Stage
+	Hook  string
+	Err   error
+}
+
+// Error implements the error interface for the AbortError.
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("%s hook %s failed: %s", e.Stage, e.Hook, e.Err)
+}
+
+// Unwrap returns the hook error.
+func (e *AbortError) Unwrap() error {
+	return e.Err
+}
+
+// Reason returns the session close reason for the failed hook.
+func (e *AbortError) Reason() string {
+	return fmt.Sprintf("hook-failed: %s", e.Hook)
+}
+
+// Load reads the hooks configuration from the JSON file.
+func Load(path string) (Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hooks config: %w", err)
+	}
+
+	var c Config
+	if err = json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse hooks config: %w", err)
+	}
+
+	for stage, hooks := range c {
+		switch stage {
+		case PreInstall, PreLaunch, PostExit, PostCleanup:
+		default:
+			return nil, fmt.Errorf("unknown hook stage: %s", stage)
+		}
+
+		for i, hook := range hooks {
+			if len(hook.Command) == 0 {
+				return nil, fmt.Errorf("%s hook #%d has no command", stage, i)
+			}
+			if hook.Name == "" {
+				c[stage][i].Name = hook.Command[0]
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// Run runs the hooks of the stage in order. A failed hook stops the stage and returns an *AbortError if it is
+// configured to abort the session, other failures are logged and the next hooks are run.
+func (c Config) Run(ctx context.Context, stage Stage, env Env) error {
+	for _, hook := range c[stage] {
+		logrus.Infof("running %s hook %s", stage, hook.Name)
+
+		err := run(ctx, hook, env)
+		if err == nil {
+			continue
+		}
+
+		if hook.AbortOnFailure {
+			return &AbortError{Stage: stage, Hook: hook.Name, Err: err}
+		}
+
+		logrus.Errorf("%s hook %s failed: %s", stage, hook.Name, err.Error())
+	}
+
+	return nil
+}
+
+// run runs the hook command with the session environment
+func run(ctx context.Context, hook Hook, env Env) error {
+	timeout := time.Duration(hook.Timeout)
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"VE_SESSION_ID="+env.SessionId,
+		"VE_APP_ID="+env.AppId,
+		"VE_RELEASE_DIR="+env.ReleaseDir,
+	)
+	if env.ExitCode != nil {
+		cmd.Env = append(cmd.Env, "VE_EXIT_CODE="+strconv.Itoa(*env.ExitCode))
+	}
+
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		logrus.Printf("%s hook output: %s", hook.Name, out)
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		// The command has not been started, e.g. the executable is missing or the context has been cancelled
+		return fmt.Errorf("failed to start: %w", err)
+	}
+
+	return err
+}
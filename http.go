@@ -5,37 +5,39 @@ import (
 	"context"
 	"dev.hackerman.me/artheon/veverse-shared/executable"
 	sm "dev.hackerman.me/artheon/veverse-shared/model"
+	vUnreal "dev.hackerman.me/artheon/veverse-shared/unreal"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gofrs/uuid"
-	"github.com/sirupsen/logrus"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"time"
+	"veverse-pixel-streaming-launcher/config"
+	"veverse-pixel-streaming-launcher/metrics"
+	"veverse-pixel-streaming-launcher/outbox"
+	"veverse-pixel-streaming-launcher/process"
 )
 
-func init() {
-	api2Root = os.Getenv("VE_API2_ROOT_URL")
-
-	if api2Root == "" {
-		logrus.Fatalf("invalid VE_API2_ROOT_URL env\n")
-	}
-}
+// apiClient is the HTTP client of the API requests recording the request metrics
+var apiClient = &http.Client{Transport: metrics.NewTransport(http.DefaultTransport)}
 
 // login authenticates user with the API
-func login() (string, error) {
+func login(c config.ApiConfig) (string, error) {
 	var (
 		requestBody []byte
 		err         error
 	)
 
 	requestBody, err = json.Marshal(map[string]string{
-		"email":    os.Getenv("USER_EMAIL"),
-		"password": os.Getenv("USER_PASSWORD"),
+		"email":    c.Email,
+		"password": c.Password,
 	})
 
 	if err != nil {
@@ -50,8 +52,7 @@ func login() (string, error) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -175,22 +176,35 @@ func downloadFile(filepath string, url string, size int64) (err error) {
 	return nil
 }
 
-func GetPendingSession(ctx context.Context) (session *sm.PixelStreamingSessionData, err error) {
+// GetPendingSession gets the pending session assigned to the instance advertising the instance capabilities, the excluded
+// sessions have been rejected by the instance. With the positive wait the API holds the request until a session is pending
+// or the wait has passed (long-polling), the request is cancelled with the context.
+func GetPendingSession(ctx context.Context, wait time.Duration, caps instanceCapabilities, exclude []string) (session *sm.PixelStreamingSessionData, requirements *sessionRequirements, err error) {
 	var (
 		req  *http.Request
 		resp *http.Response
 		body []byte
 	)
 
-	url := fmt.Sprintf("%s/pixelstreaming/session/pending", api2Root)
-	req, err = http.NewRequest("GET", url, nil)
+	query := caps.Query()
+	if wait > 0 {
+		query.Set("wait", strconv.Itoa(int(wait.Seconds())))
+	}
+	if len(exclude) > 0 {
+		query.Set("exclude", strings.Join(exclude, ","))
+	}
+
+	url := fmt.Sprintf("%s/pixelstreaming/session/pending?%s", api2Root, query.Encode())
+	req, err = http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ctx.Value("token")))
 
-	client := &http.Client{}
-	resp, err = client.Do(req)
+	resp, err = apiClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	defer func(Body io.ReadCloser) {
@@ -200,6 +214,81 @@ func GetPendingSession(ctx context.Context) (session *sm.PixelStreamingSessionDa
 		}
 	}(resp.Body)
 
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v := struct {
+		Status  string
+		Message string
+		Data    json.RawMessage
+	}{}
+
+	if err = json.Unmarshal(body, &v); err != nil {
+		return nil, nil, err
+	}
+
+	if v.Status == "error" {
+		return nil, nil, errors.New(fmt.Sprintf("authentication error %d: %s\n", resp.StatusCode, v.Message))
+	} else if v.Status != "ok" {
+		return &sm.PixelStreamingSessionData{}, nil, nil
+	}
+
+	if len(v.Data) == 0 || string(v.Data) == "null" {
+		return nil, nil, nil
+	}
+
+	// The app requirements are sent along with the session data
+	data := struct {
+		Requirements *sessionRequirements `json:"requirements"`
+	}{}
+	if err = json.Unmarshal(v.Data, &session); err != nil {
+		return nil, nil, err
+	}
+	if err = json.Unmarshal(v.Data, &data); err != nil {
+		return nil, nil, err
+	}
+
+	return session, data.Requirements, nil
+}
+
+// GetHotApps gets the ids of the most requested apps, the launcher prefetches their latest releases while idle
+func GetHotApps(ctx context.Context, limit int) (apps []uuid.UUID, err error) {
+	var (
+		req  *http.Request
+		resp *http.Response
+		body []byte
+	)
+
+	query := url.Values{}
+	query.Set("platform", vUnreal.GetPlatformName())
+	query.Set("limit", strconv.Itoa(limit))
+
+	url := fmt.Sprintf("%s/pixelstreaming/apps/hot?%s", api2Root, query.Encode())
+	req, err = http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ctx.Value("token")))
+
+	resp, err = apiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(Body io.ReadCloser) {
+		err1 := Body.Close()
+		if err1 != nil {
+			log.Printf("failed to close response body: %v", err1)
+		}
+	}(resp.Body)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("api error %d", resp.StatusCode)
+	}
+
 	body, err = io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -208,7 +297,7 @@ func GetPendingSession(ctx context.Context) (session *sm.PixelStreamingSessionDa
 	v := struct {
 		Status  string
 		Message string
-		Data    *sm.PixelStreamingSessionData
+		Data    []uuid.UUID
 	}{}
 
 	if err = json.Unmarshal(body, &v); err != nil {
@@ -216,13 +305,102 @@ func GetPendingSession(ctx context.Context) (session *sm.PixelStreamingSessionDa
 	}
 
 	if v.Status == "error" {
-		return nil, errors.New(fmt.Sprintf("authentication error %d: %s\n", resp.StatusCode, v.Message))
-	} else if v.Status == "ok" {
-		session = v.Data
-		return session, nil
+		return nil, fmt.Errorf("api error %d: %s", resp.StatusCode, v.Message)
 	}
 
-	return &sm.PixelStreamingSessionData{}, nil
+	return v.Data, nil
+}
+
+// errClaimConflict is returned when the session has been claimed by another instance or its status has changed
+var errClaimConflict = errors.New("session claim conflict")
+
+// ClaimSession atomically claims the pending session for the instance: the API sets the session status to starting only if
+// the session is still pending, and grants the instance the lease which must be renewed until the app has started.
+// errClaimConflict is returned if another instance has claimed the session first.
+func ClaimSession(ctx context.Context, id *uuid.UUID, lease time.Duration) (session *sm.PixelStreamingSessionData, err error) {
+	payload := map[string]interface{}{
+		"instanceId":     instanceId,
+		"expectedStatus": "pending",
+		"status":         "starting",
+		"leaseSeconds":   int(lease.Seconds()),
+	}
+
+	url := fmt.Sprintf("%s/pixelstreaming/session/%s/claim", api2Root, id)
+	return sendSessionClaim(ctx, http.MethodPost, url, payload)
+}
+
+// RenewSessionLease extends the lease of the session claimed by the instance, errClaimConflict is returned if the lease
+// has been lost, e.g. it has expired and the session has been claimed by another instance
+func RenewSessionLease(ctx context.Context, id *uuid.UUID, lease time.Duration) (err error) {
+	payload := map[string]interface{}{
+		"instanceId":   instanceId,
+		"leaseSeconds": int(lease.Seconds()),
+	}
+
+	url := fmt.Sprintf("%s/pixelstreaming/session/%s/lease", api2Root, id)
+	_, err = sendSessionClaim(ctx, http.MethodPut, url, payload)
+	return err
+}
+
+// sendSessionClaim sends the session claim or lease request, the conflict status or response is errClaimConflict
+func sendSessionClaim(ctx context.Context, method string, url string, payload map[string]interface{}) (session *sm.PixelStreamingSessionData, err error) {
+	var (
+		req  *http.Request
+		resp *http.Response
+		body []byte
+	)
+
+	body, err = json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ctx.Value("token")))
+
+	resp, err = apiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(Body io.ReadCloser) {
+		err1 := Body.Close()
+		if err1 != nil {
+			log.Printf("failed to close response body: %v", err1)
+		}
+	}(resp.Body)
+
+	if resp.StatusCode == http.StatusConflict {
+		return nil, errClaimConflict
+	}
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	v := struct {
+		Status  string
+		Message string
+		Data    *sm.PixelStreamingSessionData
+	}{}
+
+	if err = json.Unmarshal(body, &v); err != nil {
+		return nil, err
+	}
+
+	switch v.Status {
+	case "conflict":
+		return nil, errClaimConflict
+	case "error":
+		return nil, fmt.Errorf("api error %d: %s", resp.StatusCode, v.Message)
+	}
+
+	return v.Data, nil
 }
 
 func GetSessionData(ctx context.Context, sessionId *uuid.UUID) (session *sm.PixelStreamingSessionData, err error) {
@@ -232,13 +410,16 @@ func GetSessionData(ctx context.Context, sessionId *uuid.UUID) (session *sm.Pixe
 		body []byte
 	)
 
+	if offlineSession != nil {
+		return offlineSession.Data(), nil
+	}
+
 	url := fmt.Sprintf("%s/pixelstreaming/session/%s", api2Root, sessionId)
 	req, err = http.NewRequest("GET", url, nil)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ctx.Value("token")))
 
-	client := &http.Client{}
-	resp, err = client.Do(req)
+	resp, err = apiClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -275,17 +456,72 @@ func GetSessionData(ctx context.Context, sessionId *uuid.UUID) (session *sm.Pixe
 	return &sm.PixelStreamingSessionData{}, nil
 }
 
-func SetInstanceStatus(ctx context.Context, instanceId string, status string) (err error) {
+// GetSessionSecret gets the per-session handshake secret used to authenticate the control API requests of the signalling web server
+func GetSessionSecret(ctx context.Context, sessionId *uuid.UUID) (secret string, err error) {
 	var (
 		req  *http.Request
 		resp *http.Response
 		body []byte
 	)
 
-	body, err = json.Marshal(map[string]interface{}{
-		"instanceId": instanceId,
-		"status":     status,
-	})
+	url := fmt.Sprintf("%s/pixelstreaming/session/%s/secret", api2Root, sessionId)
+	req, err = http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ctx.Value("token")))
+
+	resp, err = apiClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}(resp.Body)
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	v := struct {
+		Status  string
+		Message string
+		Data    string
+	}{}
+
+	if err = json.Unmarshal(body, &v); err != nil {
+		return "", err
+	}
+
+	if v.Status == "error" {
+		return "", errors.New(fmt.Sprintf("get session secret error %d: %s\n", resp.StatusCode, v.Message))
+	} else if v.Data == "" {
+		return "", errors.New("empty session secret")
+	}
+
+	return v.Data, nil
+}
+
+// SendInstanceHeartbeat reports the instance status and state to the API
+func SendInstanceHeartbeat(ctx context.Context, heartbeat instanceHeartbeatData) (err error) {
+	var (
+		req  *http.Request
+		resp *http.Response
+		body []byte
+	)
+
+	if offlineSession != nil {
+		return nil
+	}
+
+	body, err = json.Marshal(heartbeat)
 	if err != nil {
 		return err
 	}
@@ -296,8 +532,7 @@ func SetInstanceStatus(ctx context.Context, instanceId string, status string) (e
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ctx.Value("token")))
 
-	client := &http.Client{}
-	resp, err = client.Do(req)
+	resp, err = apiClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -333,28 +568,70 @@ func SetInstanceStatus(ctx context.Context, instanceId string, status string) (e
 }
 
 func SetSessionStatus(ctx context.Context, id *uuid.UUID, appId *uuid.UUID, status string) (err error) {
+	return SetSessionStatusWithReason(ctx, id, appId, status, "")
+}
+
+// SetSessionStatusWithReason updates the session status providing the reason of the status change, e.g. why the session has failed.
+// The update is written to the status outbox first, so it is delivered in order once the API is reachable again.
+func SetSessionStatusWithReason(ctx context.Context, id *uuid.UUID, appId *uuid.UUID, status string, reason string) (err error) {
+	if offlineSession != nil {
+		offlineSession.SetStatus(status)
+		publishSessionStatus(id, status, reason)
+		return nil
+	}
+
+	update := sessionStatusUpdate{
+		SessionId: id,
+		AppId:     appId,
+		Status:    status,
+		Reason:    reason,
+	}
+
+	if statusOutbox == nil {
+		err = sendSessionStatus(ctx, update)
+	} else {
+		err = statusOutbox.Deliver(ctx, outboxSessionStatus, fmt.Sprintf("%s:%s", id, status), update)
+	}
+	if err != nil {
+		return err
+	}
+
+	publishSessionStatus(id, status, reason)
+
+	return nil
+}
+
+// sendSessionStatus sends the session status update to the API, the update rejected by the API fails permanently
+func sendSessionStatus(ctx context.Context, update sessionStatusUpdate) (err error) {
 	var (
 		req  *http.Request
 		resp *http.Response
 		body []byte
 	)
 
-	body, err = json.Marshal(map[string]interface{}{
-		"appId":  appId,
-		"status": status,
-	})
+	payload := map[string]interface{}{
+		"appId":  update.AppId,
+		"status": update.Status,
+	}
+	if update.Reason != "" {
+		payload["reason"] = update.Reason
+	}
+
+	body, err = json.Marshal(payload)
 	if err != nil {
-		return err
+		return outbox.Permanent(err)
 	}
 
-	url := fmt.Sprintf("%s/pixelstreaming/session/%s", api2Root, id)
+	url := fmt.Sprintf("%s/pixelstreaming/session/%s", api2Root, update.SessionId)
 	req, err = http.NewRequest(http.MethodPut, url, bytes.NewBuffer(body))
+	if err != nil {
+		return outbox.Permanent(err)
+	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ctx.Value("token")))
 
-	client := &http.Client{}
-	resp, err = client.Do(req)
+	resp, err = apiClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -371,6 +648,10 @@ func SetSessionStatus(ctx context.Context, id *uuid.UUID, appId *uuid.UUID, stat
 		return err
 	}
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("api error %d", resp.StatusCode)
+	}
+
 	v := struct {
 		Status  string
 		Message string
@@ -382,10 +663,67 @@ func SetSessionStatus(ctx context.Context, id *uuid.UUID, appId *uuid.UUID, stat
 	}
 
 	if v.Status == "error" {
-		return errors.New(fmt.Sprintf("authentication error %d: %s\n", resp.StatusCode, v.Message))
-	} else if v.Status == "ok" {
+		return outbox.Permanent(errors.New(fmt.Sprintf("authentication error %d: %s\n", resp.StatusCode, v.Message)))
+	}
+
+	return nil
+}
+
+// ReportSessionMetrics publishes the app process resource usage sample as the session metrics
+func ReportSessionMetrics(ctx context.Context, id *uuid.UUID, stats process.Stats) (err error) {
+	var (
+		req  *http.Request
+		resp *http.Response
+		body []byte
+	)
+
+	if offlineSession != nil {
 		return nil
 	}
 
+	body, err = json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/pixelstreaming/session/%s/metrics", api2Root, id)
+	req, err = http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ctx.Value("token")))
+
+	resp, err = apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}(resp.Body)
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	v := struct {
+		Status  string
+		Message string
+	}{}
+
+	if err = json.Unmarshal(body, &v); err != nil {
+		return err
+	}
+
+	if v.Status == "error" {
+		return errors.New(fmt.Sprintf("report session metrics error %d: %s\n", resp.StatusCode, v.Message))
+	}
+
 	return nil
 }
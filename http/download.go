@@ -5,13 +5,19 @@ import (
 	"context"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"github.com/wailsapp/wails/v2/pkg/runtime"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 )
 
+// downloadAttempts is the number of attempts to download the file, the transient failures are retried
+const downloadAttempts = 3
+
+// retryDelay is the delay before the first download retry, doubled after every failed attempt
+var retryDelay = time.Second
+
 // DownloadProgressTracker is a simple io.Writer that tracks the download progress using download state and callback function.
 type DownloadProgressTracker struct {
 	Current  uint64
@@ -37,21 +43,50 @@ func (c *DownloadProgressTracker) Write(p []byte) (int, error) {
 	return n, nil
 }
 
-// DownloadFile downloads a file from the specified URL to the specified path.
+// DownloadFile downloads a file from the specified URL to the specified path, the download is cancelled with the context.
+// The failed request, the server error and the interrupted transfer are retried, the client error is returned at once.
 func DownloadFile(ctx context.Context, path string, url string, counter *DownloadProgressTracker) (err error) {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = downloadFile(ctx, path, url, counter)
+		if err == nil || !retry || attempt == downloadAttempts {
+			return err
+		}
+
+		logrus.Warningf("failed to download file %s, retrying in %s: %s", url, delay, err.Error())
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// downloadFile makes a single attempt to download the file, retry is set if the failure is transient
+func downloadFile(ctx context.Context, path string, url string, counter *DownloadProgressTracker) (retry bool, err error) {
 	_, err1 := os.Stat(path)
 	if err1 == nil {
 		err2 := os.Remove(path)
 		if err2 != nil {
-			return fmt.Errorf("failed to remove file %s: %v", path, err2)
+			return false, fmt.Errorf("failed to remove file %s: %v", path, err2)
 		}
 	} else if !os.IsNotExist(err1) {
-		return fmt.Errorf("failed to check if file exists: %v", err1)
+		return false, fmt.Errorf("failed to check if file exists: %v", err1)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create a HTTP GET request: %w", err)
 	}
 
-	resp, err := http.Get(url)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send a HTTP GET request: %s\n", err.Error())
+		return ctx.Err() == nil, fmt.Errorf("failed to send a HTTP GET request: %s\n", err.Error())
 	}
 	defer func(body io.ReadCloser) {
 		err := body.Close()
@@ -61,36 +96,39 @@ func DownloadFile(ctx context.Context, path string, url string, counter *Downloa
 	}(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download file %s to %s: bad status: %s\n", url, path, resp.Status)
+		retry = resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("failed to download file %s to %s: bad status: %s\n", url, path, resp.Status)
 	}
 
 	dir := filepath.Dir(path)
 	err = os.MkdirAll(dir, 0750)
 	if err != nil {
-		return fmt.Errorf("failed to create a directory %s: %s\n", dir, err.Error())
+		return false, fmt.Errorf("failed to create a directory %s: %s\n", dir, err.Error())
 	}
 
 	out, err := os.Create(path)
 	if err != nil {
-		return fmt.Errorf("failed to create a file downloaded %s to %s: %s\n", url, path, err.Error())
+		return false, fmt.Errorf("failed to create a file downloaded %s to %s: %s\n", url, path, err.Error())
 	}
 	defer func(out *os.File) {
 		err := out.Close()
 		if err != nil {
-			runtime.LogErrorf(ctx, "error closing file: %w\n", err)
+			logrus.Errorf("error closing file: %s\n", err)
 		}
 	}(out)
 
 	// Write the body to file
 	if counter != nil {
+		// The progress of the interrupted attempt is discarded
+		counter.Current = 0
 		counter.Total = uint64(resp.ContentLength)
 		_, err = io.Copy(out, io.TeeReader(resp.Body, counter))
 	} else {
 		_, err = io.Copy(out, resp.Body)
 	}
 	if err != nil {
-		return fmt.Errorf("failed to write a file downloaded %s to %s: %s\n", url, path, err.Error())
+		return ctx.Err() == nil, fmt.Errorf("failed to write a file downloaded %s to %s: %s\n", url, path, err.Error())
 	}
 
-	return nil
+	return false, nil
 }
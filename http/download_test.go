@@ -0,0 +1,130 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// noRetryDelay retries the failed downloads at once until the test ends
+func noRetryDelay(t *testing.T) {
+	delay := retryDelay
+	retryDelay = time.Millisecond
+	t.Cleanup(func() {
+		retryDelay = delay
+	})
+}
+
+func TestDownloadFileRetry(t *testing.T) {
+	noRetryDelay(t)
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch requests.Add(1) {
+		case 1:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case 2:
+			// Interrupt the transfer after a part of the body
+			w.Header().Set("Content-Length", "1024")
+			_, _ = w.Write([]byte("partial"))
+		default:
+			_, _ = w.Write([]byte("content"))
+		}
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "dir", "file")
+	counter := NewDownloadProgressTracker(0, nil)
+	err := DownloadFile(context.Background(), path, srv.URL, counter)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if n := requests.Load(); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the downloaded file: %s", err.Error())
+	}
+	if string(b) != "content" {
+		t.Errorf("got content %q, want %q", b, "content")
+	}
+	if counter.Current != uint64(len(b)) {
+		t.Errorf("got progress %d, want %d", counter.Current, len(b))
+	}
+}
+
+func TestDownloadFileAttempts(t *testing.T) {
+	noRetryDelay(t)
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	err := DownloadFile(context.Background(), filepath.Join(t.TempDir(), "file"), srv.URL, nil)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if n := requests.Load(); n != downloadAttempts {
+		t.Errorf("got %d requests, want %d", n, downloadAttempts)
+	}
+}
+
+func TestDownloadFileClientError(t *testing.T) {
+	noRetryDelay(t)
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	err := DownloadFile(context.Background(), filepath.Join(t.TempDir(), "file"), srv.URL, nil)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestDownloadFileCancelled(t *testing.T) {
+	// The cancelled download does not wait for the retry
+	delay := retryDelay
+	retryDelay = time.Hour
+	t.Cleanup(func() {
+		retryDelay = delay
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- DownloadFile(ctx, filepath.Join(t.TempDir(), "file"), srv.URL, nil)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("expected error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the cancelled download has not returned")
+	}
+}
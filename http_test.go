@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveApi points the API root at the test server until the test ends
+func serveApi(t *testing.T, handler http.HandlerFunc) {
+	srv := httptest.NewServer(handler)
+	root, instance := api2Root, instanceId
+	api2Root, instanceId = srv.URL, "instance"
+	t.Cleanup(func() {
+		api2Root, instanceId = root, instance
+		srv.Close()
+	})
+}
+
+func TestClaimSession(t *testing.T) {
+	id := uuid.Must(uuid.NewV4())
+
+	serveApi(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/pixelstreaming/session/"+id.String()+"/claim" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if payload["instanceId"] != "instance" || payload["expectedStatus"] != "pending" || payload["status"] != "starting" || payload["leaseSeconds"] != float64(30) {
+			t.Errorf("unexpected claim payload %v", payload)
+		}
+
+		_, _ = w.Write([]byte(`{"status":"ok","data":{"id":"` + id.String() + `","status":"starting"}}`))
+	})
+
+	ctx := context.WithValue(context.Background(), "token", "token")
+	session, err := ClaimSession(ctx, &id, 30*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if session == nil || session.Id == nil || *session.Id != id || session.Status != "starting" {
+		t.Errorf("unexpected claimed session %+v", session)
+	}
+}
+
+func TestClaimSessionConflict(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		body string
+	}{
+		{name: "conflict status code", code: http.StatusConflict},
+		{name: "conflict response", code: http.StatusOK, body: `{"status":"conflict","message":"the session is not pending"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serveApi(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.code)
+				_, _ = w.Write([]byte(tt.body))
+			})
+
+			id := uuid.Must(uuid.NewV4())
+			_, err := ClaimSession(context.Background(), &id, time.Minute)
+			if !errors.Is(err, errClaimConflict) {
+				t.Errorf("got error %v, want %v", err, errClaimConflict)
+			}
+		})
+	}
+}
+
+func TestClaimSessionError(t *testing.T) {
+	serveApi(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"status":"error","message":"internal error"}`))
+	})
+
+	id := uuid.Must(uuid.NewV4())
+	_, err := ClaimSession(context.Background(), &id, time.Minute)
+	if err == nil || errors.Is(err, errClaimConflict) {
+		t.Errorf("got error %v, want the API error", err)
+	}
+}
+
+func TestRenewSessionLease(t *testing.T) {
+	id := uuid.Must(uuid.NewV4())
+
+	serveApi(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/pixelstreaming/session/"+id.String()+"/lease" {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"status":"ok"}`))
+	})
+
+	err := RenewSessionLease(context.Background(), &id, time.Minute)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Liveness event types reported by the signalling server
+const (
+	livenessPlayerConnected    = "player-connected"
+	livenessPlayerDisconnected = "player-disconnected"
+	livenessHeartbeat          = "heartbeat"
+)
+
+// livenessEvent is the player event reported by the signalling server
+type livenessEvent struct {
+	Type     string `json:"type"`
+	PlayerId string `json:"playerId"`
+}
+
+// livenessState is the snapshot of the liveness tracker reported by the status endpoint
+type livenessState struct {
+	Viewers      int       `json:"viewers"`
+	LastEventAt  time.Time `json:"lastEventAt,omitempty"`
+	LastActiveAt time.Time `json:"lastActiveAt"`
+	IdleSeconds  int       `json:"idleSeconds"`
+}
+
+// livenessTracker tracks the viewers connected to the session using the player events of the signalling server.
+// A viewer without a heartbeat for the heartbeat timeout is considered disconnected.
+type livenessTracker struct {
+	mu               sync.Mutex
+	heartbeatTimeout time.Duration
+	players          map[string]time.Time // Time of the last event of each connected player
+	lastEventAt      time.Time
+	lastActiveAt     time.Time // Time a viewer has been connected last time
+}
+
+// newLivenessTracker creates a new livenessTracker
+func newLivenessTracker(heartbeatTimeout time.Duration) *livenessTracker {
+	return &livenessTracker{
+		heartbeatTimeout: heartbeatTimeout,
+		players:          map[string]time.Time{},
+		lastActiveAt:     time.Now(),
+	}
+}
+
+// HandleEvent updates the tracker with the player event
+func (t *livenessTracker) HandleEvent(event livenessEvent) error {
+	if event.PlayerId == "" {
+		return fmt.Errorf("player id is required")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.expire(now)
+
+	switch event.Type {
+	case livenessPlayerConnected, livenessHeartbeat:
+		t.players[event.PlayerId] = now
+	case livenessPlayerDisconnected:
+		if _, ok := t.players[event.PlayerId]; ok {
+			delete(t.players, event.PlayerId)
+			t.lastActiveAt = now
+		}
+	default:
+		return fmt.Errorf("unknown event type: %s", event.Type)
+	}
+
+	t.lastEventAt = now
+	if len(t.players) > 0 {
+		t.lastActiveAt = now
+	}
+
+	return nil
+}
+
+// SetHeartbeatTimeout updates the heartbeat timeout, e.g. after the configuration is reloaded
+func (t *livenessTracker) SetHeartbeatTimeout(heartbeatTimeout time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.heartbeatTimeout = heartbeatTimeout
+}
+
+// MarkActive resets the idle time, e.g. when the app has just started and viewers have not connected yet
+func (t *livenessTracker) MarkActive() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastActiveAt = time.Now()
+}
+
+// IdleSince returns the time since the last viewer has been connected, zero if any viewers are connected
+func (t *livenessTracker) IdleSince(now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire(now)
+	if len(t.players) > 0 {
+		return 0
+	}
+
+	return now.Sub(t.lastActiveAt)
+}
+
+// State returns the tracker state snapshot
+func (t *livenessTracker) State() livenessState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.expire(now)
+
+	state := livenessState{
+		Viewers:      len(t.players),
+		LastEventAt:  t.lastEventAt,
+		LastActiveAt: t.lastActiveAt,
+	}
+	if state.Viewers == 0 {
+		state.IdleSeconds = int(now.Sub(t.lastActiveAt).Seconds())
+	}
+
+	return state
+}
+
+// expire removes the players without a heartbeat for the heartbeat timeout, must be called with the lock held
+func (t *livenessTracker) expire(now time.Time) {
+	for id, seenAt := range t.players {
+		if now.Sub(seenAt) > t.heartbeatTimeout {
+			delete(t.players, id)
+			// The player has been seen alive last time at its last heartbeat
+			if seenAt.After(t.lastActiveAt) {
+				t.lastActiveAt = seenAt
+			}
+		}
+	}
+}
@@ -1,6 +1,6 @@
 /*
 1. The launcher should start automatically after starting/restarting the instance.
-2. After starting, the launcher finds a pending session, get the session_id, instance_id, instance_type, app_id and world_id, and sets the session status to "starting."
+2. After starting, the launcher finds a pending session, get the session_id, instance_id, instance_type, app_id and world_id, and claims it setting the session status to "starting."
 3. Launcher clears all user data.
 4. It downloads the necessary app, installs and launches it. Switches the session status to "Running."
 5. It periodically checks the status, if the status is "Closed" it closes the app.
@@ -12,69 +12,89 @@ import (
 	"context"
 	sl "dev.hackerman.me/artheon/veverse-shared/log"
 	sm "dev.hackerman.me/artheon/veverse-shared/model"
-	"flag"
 	"fmt"
-	"github.com/gofrs/uuid"
 	"github.com/sirupsen/logrus"
-	"io"
 	"log"
 	"os"
-	"os/exec"
-	"path"
-	"path/filepath"
 	"strings"
-	"syscall"
 	"time"
 	"veverse-pixel-streaming-launcher/api"
 	"veverse-pixel-streaming-launcher/config"
 	"veverse-pixel-streaming-launcher/database"
+	"veverse-pixel-streaming-launcher/hooks"
 )
 
+// migrateTimeout is the timeout of the schema migrations
+const migrateTimeout = time.Minute
+
 var (
-	pEnvironment string // environment (development, test or production), will use a corresponding API to get jobs for processing
+	configSource *config.Source
+	appArgs      []string // Additional command line arguments of the app
 	api2Root     string
 	instanceId   string
 
-	NewSessionCheckTime = time.Duration(30) * time.Second
-	session             *sm.PixelStreamingSessionData
-	isAppLaunch         bool
-	latestRelease       *sm.ReleaseV2
-	cmd                 *exec.Cmd
-	cancel              context.CancelFunc
+	isAppLaunch   bool
+	latestRelease *sm.ReleaseV2
+	appHooks      hooks.Config
 )
 
-func init() {
-	flag.StringVar(&pEnvironment, "env", "", "Environment: dev, test or prod")
-	flag.Parse()
+func main() {
+	//region Load configuration
 
-	//region Parse environment variables
+	c, source, args, err := config.Load(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatalf("failed to load config: %s\n", err.Error())
+	}
 
-	if pEnvironment == "" {
-		pEnvironment = "test"
+	currentConfig.Store(c)
+	configSource = source
+
+	if len(args) > 0 && (args[0] == "config" || args[0] == "migrate") {
+		os.Exit(runCommand(args))
 	}
+	appArgs = args
 
-	instanceId = os.Getenv("INSTANCE_ID")
+	api2Root = c.Api.Url
+	api.SetRoot(c.Api.Url)
+	instanceId = c.InstanceId
 
-	isAppLaunch = false
-	session = &sm.PixelStreamingSessionData{}
 	//endregion
-}
-
-func main() {
 
 	fmt.Println("Welcome to the VeVerse pixel streaming launcher")
 
+	err = cfg().Validate()
+	if err != nil {
+		log.Fatalf("invalid config: %s\n", err.Error())
+	}
+
+	level, _ := logrus.ParseLevel(cfg().LogLevel)
+	logrus.SetLevel(level)
+
+	if cfg().HooksFile != "" {
+		appHooks, err = hooks.Load(cfg().HooksFile)
+		if err != nil {
+			log.Fatalf("failed to load hooks: %s\n", err.Error())
+		}
+	}
+
 	//region Authenticate and get the JWT
 	// create context for web server with cancel function
-	ctx, cancel = context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 
-	var err error
-
-	ctx, err = database.SetupClickhouse(ctx)
+	ctx, err = database.SetupClickhouse(ctx, cfg().ClickHouse)
 	if err != nil {
 		logrus.Errorf("failed to setup clickhouse: %s\n", err.Error())
+	} else if cfg().ClickHouse.Migrate {
+		go func() {
+			_, err := migrateSchema(ctx)
+			if err != nil {
+				logrus.Errorf("failed to migrate the schema: %s\n", err.Error())
+			}
+		}()
 	}
 
+	startTelemetry(ctx)
+
 	var hook logrus.Hook
 	hook, err = sl.NewHook(ctx)
 	if err != nil {
@@ -83,181 +103,266 @@ func main() {
 		logrus.AddHook(hook)
 	}
 
-	token, err := login()
-	if err != nil {
-		logrus.Errorf("failed to login: %s\n", err.Error())
+	if cfg().LocalRelease != "" {
+		// The offline mode runs the local release without the control plane
+		offlineSession, err = newLocalSession(cfg().LocalRelease)
+		if err != nil {
+			log.Fatalf("failed to create the local session: %s\n", err.Error())
+		}
 	} else {
-		ctx = context.WithValue(ctx, "token", token)
+		token, err := login(cfg().Api)
+		if err != nil {
+			logrus.Errorf("failed to login: %s\n", err.Error())
+		} else {
+			ctx = context.WithValue(ctx, "token", token)
+		}
+
+		// Deliver the status updates spooled while the API was unavailable before reporting the new ones
+		err = openStatusOutbox(ctx)
+		if err != nil {
+			logrus.Errorf("failed to open status outbox, the status updates are not retried: %s\n", err.Error())
+		}
 	}
 
 	//endregion
 
-	err = SetInstanceStatus(ctx, instanceId, "free")
+	manager := newSessionManager()
+	auth := newRequestAuthenticator(cfg().Control.Secret, cfg().Control.MaxClockSkew)
 
-	// start web server for cirrus session management
-	go startWebServer(ctx)
-
-	// region check pending session
-	for {
-		// get pending session
-		session, err = GetPendingSession(ctx)
-		if err != nil {
-			logrus.Errorf("failed to get latest release: %s\n", err.Error())
-		}
+	// Reconcile the session of the previous run before the instance heartbeat reports the instance free
+	var session *sm.PixelStreamingSessionData
+	var adopted bool
+	if offlineSession == nil {
+		session, adopted = recoverSession(ctx, manager)
+	}
 
-		if session != nil && session.Id != nil {
-			break
-		}
+	heartbeat := newInstanceHeartbeat(manager)
+	go heartbeat.Run(ctx)
 
-		time.Sleep(NewSessionCheckTime)
-	}
-	// endregion
+	drain := newDrainController(manager, heartbeat)
+	go watchDrainSignal(ctx, drain)
 
-	//region change session status & launch app
-	for {
-		if !isAppLaunch && session.Id != nil {
-			// update session status to starting
-			err = SetSessionStatus(ctx, session.Id, session.AppId, "starting")
-			if err != nil {
-				log.Fatalf("failed to set session status to starting: %s\n", err.Error())
+	// start web server for cirrus session management
+	reloader := newConfigReloader(configSource, func(c *config.Launcher) {
+		level, _ := logrus.ParseLevel(c.LogLevel)
+		logrus.SetLevel(level)
+		manager.ApplyConfig(c)
+	})
+	go reloader.Run(ctx)
+
+	server := newControlServer(ctx, manager, auth, launcherEvents, reloader, heartbeat, drain, cancel, cfg)
+	serverErrs, err := startWebServer(ctx, cfg().Control, server)
+	if err != nil {
+		logrus.Errorf("failed to start web server: %s\n", err.Error())
+		heartbeat.SetUnhealthy()
+	} else {
+		go func() {
+			for err := range serverErrs {
+				logrus.Errorf("web server failed: %s\n", err.Error())
+				heartbeat.SetUnhealthy()
 			}
+		}()
+	}
 
-			// launch app
-			latestRelease, err = api.GetLatestReleaseV2(ctx, *session.AppId)
-			if err != nil || latestRelease == nil {
-				log.Fatalf("failed to get the latest release: %s\n", err.Error())
-			}
+	if offlineSession != nil {
+		runLocalSession(ctx, manager)
+		<-ctx.Done()
+		stopTelemetry()
+		return
+	}
 
-			//region Download binaries
+	if adopted {
+		setSessionSecret(ctx, auth, manager.Session())
+		<-ctx.Done()
+		stopTelemetry()
+		return
+	}
 
-			if latestRelease.Files == nil || latestRelease.Files.Entities == nil || len(latestRelease.Files.Entities) == 0 {
-				log.Fatalf("no files in the release\n")
+	//region wait for pending session & launch app
+	assigner := newSessionAssigner(drain)
+	var prefetcher *releasePrefetcher
+	claimed := session == nil
+	for {
+		if session == nil {
+			// Warm the cache with the hot app releases until a session is claimed
+			prefetcher = startPrefetcher(ctx)
+			session = assigner.Next(ctx, prefetcher)
+			if session == nil {
+				prefetcher.Stop()
+				logrus.Infof("the instance is draining, not waiting for a pending session")
+				break
 			}
+		}
 
-			if latestRelease.Archive {
-				err = installAppReleaseArchive(ctx, *session.AppId, *latestRelease)
-				if err != nil {
-					log.Fatalf("failed to download the archive: %s\n", err.Error())
-				}
-			} else {
-				err = installAppRelease(ctx, *session.AppId, *latestRelease)
-				if err != nil {
-					log.Fatalf("failed to download the files: %s\n", err.Error())
-				}
-			}
+		manager.SetSession(session)
+		setSessionSecret(ctx, auth, session)
 
-			// update session status to running
-			err = SetSessionStatus(ctx, session.Id, session.AppId, "running")
-			if err != nil {
-				log.Fatalf("failed to set session status to starting: %s\n", err.Error())
-			}
+		// The session returns to pending unless its lease is renewed until the app is running
+		lease := newSessionLease(ctx, manager, session.Id)
+		go lease.Run()
 
-			isAppLaunch = true
+		// The prefetch has been cancelled by the claim, the release being prefetched is abandoned while the lease is renewed
+		prefetcher.Wait()
+		prefetcher = nil
 
-			runApp(ctx, *session.AppId, latestRelease)
-		} else if session.Id != nil {
+		launched := launchSession(ctx, manager, session, claimed, lease, reloader)
+		lease.Stop()
+		if launched {
+			// The launcher runs a single app session
 			break
 		}
 
-		time.Sleep(NewSessionCheckTime)
+		// The session has been closed before the app has started, e.g. the lease has been lost, wait for another one
+		manager.Reset()
+		analytics.Reset()
+		session, isAppLaunch, claimed = nil, false, true
 	}
 	//endregion
 
 	<-ctx.Done()
+	stopTelemetry()
 }
 
-func runApp(ctx context.Context, id uuid.UUID, r *sm.ReleaseV2) {
-	//region Entrypoint
+// launchSession installs the session app release and runs the app until it exits, false is returned if the session has
+// been closed before the app has started. The reloaded app and instance settings are applied right before the app starts.
+func launchSession(ctx context.Context, manager *sessionManager, session *sm.PixelStreamingSessionData, claimed bool, lease *sessionLease, reloader *configReloader) bool {
+	// update session status to starting, the claim has already set it
+	if claimed {
+		publishSessionStatus(session.Id, "starting", "")
+	} else {
+		err := SetSessionStatus(ctx, session.Id, session.AppId, "starting")
+		if err != nil {
+			log.Fatalf("failed to set session status to starting: %s\n", err.Error())
+		}
+	}
 
-	entrypoint, err := findEntrypoint(filepath.Join(config.AppDir, id.String(), r.Id.String()+"-"+r.Version))
-	if err != nil || entrypoint == "" {
-		log.Fatalf("failed to find an entrypoint: %s\n", err.Error())
+	// launch app
+	var err error
+	latestRelease, err = api.GetLatestReleaseV2(ctx, *session.AppId)
+	if err != nil || latestRelease == nil {
+		log.Fatalf("failed to get the latest release: %s\n", err.Error())
 	}
+	analytics.SetRelease(latestRelease.Version)
 
-	projectName := getProjectName(entrypoint)
+	//region Download binaries
 
-	// Get the PROJECT_DIR basing on the entrypoint as "../../../"
-	projectDir := path.Dir(path.Dir(path.Dir(path.Dir(entrypoint)))) + "/"
-	// Check if we need to normalize the entrypoint to the PROJECT_DIR
-	if strings.Count(entrypoint, "/") > 3 {
-		// Check if we need to remove excessive path prefix
-		if !strings.HasPrefix(entrypoint, projectName) {
-			// Normalize entrypoint to the PROJECT_DIR by removing excessive prefix
-			entrypoint = strings.Replace(entrypoint, projectDir, "", 1)
-		}
+	if latestRelease.Files == nil || latestRelease.Files.Entities == nil || len(latestRelease.Files.Entities) == 0 {
+		log.Fatalf("no files in the release\n")
 	}
 
-	log.Printf("using entrypoint: %s\n", entrypoint)
-
-	//endregion
+	err = appHooks.Run(ctx, hooks.PreInstall, newHookEnv(session, *session.AppId, appReleaseDir(*session.AppId, latestRelease)))
+	if err != nil {
+		abortSession(ctx, session, err)
+	}
 
-	//region Command arguments
+	manager.SetPhase(phaseInstalling)
 
-	// Set the first command line argument as the project name
-	var args = []string{"-PixelStreamingIP=127.0.0.1", "-PixelStreamingPort=8888", "-RenderOffScreen", "-ForceRes", "-ResX=1920", "-ResY=1080"}
-	// Append additional command line arguments if any of them present
-	args = append(args, os.Args[1:]...)
+	// The installation is cancelled once the lease has been lost
+	installCtx := lease.Context()
+	if releaseInstalled(*session.AppId, latestRelease) {
+		logrus.Infof("using the installed release %s", latestRelease.Version)
+	} else if latestRelease.Archive {
+		err = installAppReleaseArchive(installCtx, *session.AppId, *latestRelease)
+		if err != nil && !lease.Lost() {
+			log.Fatalf("failed to download the archive: %s\n", err.Error())
+		}
+	} else {
+		err = installAppRelease(installCtx, *session.AppId, *latestRelease)
+		if err != nil && !lease.Lost() {
+			log.Fatalf("failed to download the files: %s\n", err.Error())
+		}
+	}
 
 	//endregion
 
-	//region Prepare and run the server command
-	cmd = exec.Command(entrypoint, args...)
-	cmd.Dir = projectDir // Change the current working directory for the process to the PROJECT_DIR
-	cmd.Env = os.Environ()
-	rd, err := cmd.StdoutPipe()
 	if err != nil {
-		log.Fatalf("failed to attach to a application stdout pipe: %s\n", err.Error())
+		// The partially installed release is not reused
+		err = os.RemoveAll(appReleaseDir(*session.AppId, latestRelease))
+		if err != nil {
+			logrus.Errorf("failed to remove the partially installed release: %s\n", err.Error())
+		}
+	} else {
+		markReleaseUsed(appReleaseDir(*session.AppId, latestRelease))
 	}
 
-	// Read output from the server process
-	go func() {
-		b := make([]byte, 2048)
-		for {
-			nn, err := rd.Read(b)
-			if nn > 0 {
-				log.Printf("%s", b[:nn])
-			}
-			if err != nil {
-				if err == io.EOF {
-					log.Printf("the application process has exited\n")
-				} else {
-					log.Fatalf("failed to read the application process pipe: %s\n", err.Error())
-				}
-				return
-			}
+	isAppLaunch = true
+
+	if lease.Lost() || manager.Status().Phase == phaseClosed {
+		// The session has been closed while the release was installed, e.g. at the drain deadline or the lease has been lost
+		logrus.Infof("the session %s has been closed before the application has started", session.Id)
+		reason := ""
+		if lease.Lost() {
+			reason = closeReasonLeaseLost
 		}
-	}()
+		analytics.Finish("closed", reason, -1)
+		manager.clearState()
+		return false
+	}
+
+	reloader.ApplySession()
+	manager.runApp(ctx, *session.AppId, appReleaseDir(*session.AppId, latestRelease))
+
+	// The app stopped while starting once the lease has been lost does not end the launcher run
+	return !lease.Lost()
+}
 
-	if err := cmd.Start(); err != nil {
-		log.Fatalf("cmd.Start() error: %v\n", err)
+// setSessionSecret sets the session secret, the signalling web server gets the same secret from the API to sign the control requests
+func setSessionSecret(ctx context.Context, auth *requestAuthenticator, session *sm.PixelStreamingSessionData) {
+	secret, err := GetSessionSecret(ctx, session.Id)
+	if err != nil {
+		logrus.Errorf("failed to get session secret: %s\n", err.Error())
+		return
 	}
 
-	if err := cmd.Wait(); err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			// The program has exited with an exit code != 0
-			// This usually means that the server process has crashed
-			err = SetSessionStatus(ctx, session.Id, session.AppId, "closed")
-			if err != nil {
-				log.Fatalf("failed to set session status to starting: %s\n", err.Error())
-			}
+	auth.SetSecret(secret)
+}
 
-			if status, ok := exitError.Sys().(syscall.WaitStatus); ok {
-				log.Fatalf("application exit code: %v\n", status.ExitStatus())
-			}
-		} else {
-			err = SetSessionStatus(ctx, session.Id, session.AppId, "closed")
-			if err != nil {
-				log.Fatalf("failed to set session status to starting: %s\n", err.Error())
-			}
-			log.Fatalf("application exit error: %v\n", err)
+// runCommand runs the launcher command instead of the launcher loop and returns the exit code
+func runCommand(args []string) int {
+	switch {
+	case len(args) == 2 && args[0] == "config" && args[1] == "show":
+		if configSource.Path != "" {
+			fmt.Printf("# %s\n", configSource.Path)
 		}
-	} else {
-		log.Printf("application exited normally\n")
-		err = SetSessionStatus(ctx, session.Id, session.AppId, "closed")
+
+		err := cfg().Print(os.Stdout)
 		if err != nil {
-			log.Fatalf("failed to set session status to starting: %s\n", err.Error())
+			logrus.Errorf("failed to print config: %s\n", err.Error())
+			return 1
+		}
+
+		err = cfg().Validate()
+		if err != nil {
+			logrus.Errorf("invalid config: %s\n", err.Error())
+			return 1
 		}
+
+		return 0
+	case len(args) == 1 && args[0] == "migrate":
+		ctx, err := database.SetupClickhouse(context.Background(), cfg().ClickHouse)
+		if err != nil {
+			logrus.Errorf("failed to setup clickhouse: %s\n", err.Error())
+			return 1
+		}
+
+		applied, err := migrateSchema(ctx)
+		if err != nil {
+			logrus.Errorf("failed to migrate the schema: %s\n", err.Error())
+			return 1
+		}
+
+		fmt.Printf("applied %d migrations\n", applied)
+		return 0
 	}
 
-	//endregion
+	logrus.Errorf("unknown command: %s\n", strings.Join(args, " "))
+	return 2
+}
+
+// migrateSchema applies the launcher schema migrations to ClickHouse
+func migrateSchema(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, migrateTimeout)
+	defer cancel()
+
+	return database.Migrate(ctx, database.Clickhouse)
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	sm "dev.hackerman.me/artheon/veverse-shared/model"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+	"veverse-pixel-streaming-launcher/utils"
+)
+
+// releaseManifestFile is the name of the manifest file in the release directory, it is written once the release has
+// been installed completely
+const releaseManifestFile = ".manifest.json"
+
+// releaseManifest lists the files of the installed release, the cached release is reused only if it still matches
+type releaseManifest struct {
+	Sources map[string]int64 `json:"sources"` // Sizes of the release files the release has been installed from, by the file id
+	Files   map[string]int64 `json:"files"`   // Sizes of the installed files, by the path relative to the release directory
+}
+
+// releaseSources returns the sizes of the release files the release is installed from, by the file id
+func releaseSources(release *sm.ReleaseV2) map[string]int64 {
+	fileType := "release"
+	if release.Archive {
+		fileType = "release-archive"
+	}
+
+	sources := map[string]int64{}
+	if release.Files == nil {
+		return sources
+	}
+
+	for _, file := range release.Files.Entities {
+		if file.Type != fileType {
+			continue
+		}
+
+		var size int64
+		if file.Size != nil {
+			size = *file.Size
+		}
+		sources[file.Id.String()] = size
+	}
+
+	return sources
+}
+
+// writeReleaseManifest lists the files installed to the release directory in the manifest
+func writeReleaseManifest(dir string, release *sm.ReleaseV2) error {
+	m := releaseManifest{
+		Sources: releaseSources(release),
+		Files:   map[string]int64{},
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == releaseManifestFile {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		m.Files[filepath.ToSlash(rel)] = info.Size()
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list the release files: %w", err)
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode the release manifest: %w", err)
+	}
+
+	err = utils.WriteFileAtomic(filepath.Join(dir, releaseManifestFile), b)
+	if err != nil {
+		return fmt.Errorf("failed to write the release manifest: %w", err)
+	}
+
+	return nil
+}
+
+// markReleaseUsed records the use of the installed release by a session as the manifest modification time, the least
+// recently used releases are evicted first
+func markReleaseUsed(dir string) {
+	now := time.Now()
+	err := os.Chtimes(filepath.Join(dir, releaseManifestFile), now, now)
+	if err != nil {
+		logrus.Warningf("failed to mark the release %s used: %s", dir, err.Error())
+	}
+}
+
+// verifyReleaseManifest checks that the installed release has been installed from the release files and none of the
+// installed files is missing or has changed its size
+func verifyReleaseManifest(dir string, release *sm.ReleaseV2) error {
+	b, err := os.ReadFile(filepath.Join(dir, releaseManifestFile))
+	if err != nil {
+		return fmt.Errorf("failed to read the release manifest: %w", err)
+	}
+
+	var m releaseManifest
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return fmt.Errorf("failed to decode the release manifest: %w", err)
+	}
+
+	sources := releaseSources(release)
+	if len(sources) != len(m.Sources) {
+		return fmt.Errorf("the release files have changed")
+	}
+	for id, size := range sources {
+		if installed, ok := m.Sources[id]; !ok || installed != size {
+			return fmt.Errorf("the release file %s has changed", id)
+		}
+	}
+
+	for path, size := range m.Files {
+		info, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			return fmt.Errorf("the release file %s is missing: %w", path, err)
+		}
+		if info.Size() != size {
+			return fmt.Errorf("the release file %s has %d bytes, %d expected", path, info.Size(), size)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Default is the registry of the launcher metrics.
+var Default = NewRegistry()
+
+// Launcher metrics.
+var (
+	DownloadBytes = Default.NewCounterVec("launcher_download_bytes_total",
+		"Bytes of the app release files downloaded.", "app_id", "release")
+	DownloadDuration = Default.NewGaugeVec("launcher_download_duration_seconds",
+		"Duration of the last app release download.", "app_id", "release")
+	DownloadThroughput = Default.NewGaugeVec("launcher_download_throughput_bytes_per_second",
+		"Throughput of the last app release download.", "app_id", "release")
+	ExtractDuration = Default.NewGaugeVec("launcher_extract_duration_seconds",
+		"Duration of the last app release archive extraction.", "app_id", "release")
+
+	APIRequestDuration = Default.NewHistogramVec("launcher_api_request_duration_seconds",
+		"Latency of the API requests.", DefaultBuckets, "endpoint")
+	APIErrors = Default.NewCounterVec("launcher_api_errors_total",
+		"API requests failed or answered with an error status.", "endpoint")
+
+	SessionState = Default.NewGaugeVec("launcher_session_state",
+		"Current session phase of the launcher, 1 for the current phase and 0 for others.", "phase")
+	AppRestarts = Default.NewCounterVec("launcher_app_restarts_total",
+		"App restarts requested through the control API.")
+	TimeToRunning = Default.NewGaugeVec("launcher_time_to_running_seconds",
+		"Time from the session claim to the app registering at the signalling server.")
+
+	AppRSS = Default.NewGaugeVec("launcher_app_rss_bytes",
+		"Resident memory of the app process.")
+	AppCPUSeconds = Default.NewGaugeVec("launcher_app_cpu_seconds",
+		"Total CPU time consumed by the app process.")
+	AppCPUPercent = Default.NewGaugeVec("launcher_app_cpu_percent",
+		"CPU usage of the app process between the last two samples.")
+	AppOpenFiles = Default.NewGaugeVec("launcher_app_open_files",
+		"Open file descriptors of the app process.")
+
+	TelemetryEvents = Default.NewCounterVec("launcher_telemetry_events_total",
+		"Telemetry events by the result: written, spilled to disk, replayed from disk or dropped.", "result")
+)
+
+// idPattern matches the UUIDs and numeric ids in the URL paths
+var idPattern = regexp.MustCompile(`/([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9]+)(/|$)`)
+
+// Endpoint returns the low-cardinality endpoint label for the request, replacing the ids in the path with ":id".
+func Endpoint(method string, path string) string {
+	// Replace twice as the adjacent ids share the separating slash
+	path = idPattern.ReplaceAllString(path, "/:id$2")
+	path = idPattern.ReplaceAllString(path, "/:id$2")
+	return method + " " + path
+}
+
+// transport is the http.RoundTripper recording the API request latency and errors
+type transport struct {
+	base http.RoundTripper
+}
+
+// NewTransport wraps the base transport recording the API request metrics.
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	return &transport{base: base}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := Endpoint(req.Method, req.URL.Path)
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	APIRequestDuration.Observe(time.Since(start).Seconds(), endpoint)
+
+	if err != nil || resp.StatusCode >= 400 {
+		APIErrors.Inc(endpoint)
+	}
+
+	return resp, err
+}
+
+// SetSessionPhase sets the session state gauges for the current phase.
+func SetSessionPhase(current string, phases []string) {
+	for _, phase := range phases {
+		v := 0.0
+		if phase == current {
+			v = 1
+		}
+		SessionState.Set(v, phase)
+	}
+}
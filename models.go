@@ -14,7 +14,7 @@ var binarySuffixes = map[string]bool{
 }
 
 func getBinarySuffix() string {
-	env := strings.ToLower(pEnvironment)
+	env := strings.ToLower(cfg().Environment)
 
 	if //goland:noinspection GoBoolExpressions
 	goRuntime.GOOS == "windows" {
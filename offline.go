@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	sm "dev.hackerman.me/artheon/veverse-shared/model"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"veverse-pixel-streaming-launcher/hooks"
+	"veverse-pixel-streaming-launcher/metrics"
+	"veverse-pixel-streaming-launcher/utils"
+)
+
+// localReleaseVersion is the release version label of the local release in the metrics
+const localReleaseVersion = "local"
+
+// localSession is the session synthesised by the offline mode, its status is kept locally instead of the API
+type localSession struct {
+	mu   sync.Mutex
+	data sm.PixelStreamingSessionData
+}
+
+// offlineSession is the session of the offline mode, nil when the launcher works with the API
+var offlineSession *localSession
+
+// newLocalSession synthesises the session of the local release, the app id is derived from the release path
+func newLocalSession(releasePath string) (*localSession, error) {
+	abs, err := filepath.Abs(releasePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the local release path: %w", err)
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the session id: %w", err)
+	}
+
+	appId := uuid.NewV5(uuid.NamespaceURL, "file://"+filepath.ToSlash(abs))
+
+	return &localSession{
+		data: sm.PixelStreamingSessionData{
+			Id:     &id,
+			AppId:  &appId,
+			Status: "pending",
+		},
+	}, nil
+}
+
+// Data returns a copy of the session data
+func (s *localSession) Data() *sm.PixelStreamingSessionData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := s.data
+	return &data
+}
+
+// SetStatus updates the session status
+func (s *localSession) SetStatus(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Status = status
+}
+
+// localReleaseDir returns the directory the local release runs from: the release directory itself, or the app
+// directory the release archive is extracted to
+func localReleaseDir(appId uuid.UUID, releasePath string) (string, error) {
+	info, err := os.Stat(releasePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat the local release: %w", err)
+	}
+
+	if info.IsDir() {
+		return releasePath, nil
+	}
+
+	return filepath.Join(cfg().Dirs.Apps, appId.String(), localReleaseVersion), nil
+}
+
+// installLocalRelease extracts the local release archive, a local release directory is used in place
+func installLocalRelease(ctx context.Context, appId uuid.UUID, releasePath string, releaseDir string) error {
+	if releaseDir == releasePath {
+		return nil
+	}
+
+	err := os.RemoveAll(releaseDir)
+	if err != nil {
+		return fmt.Errorf("failed to remove the previous local release: %w", err)
+	}
+
+	startedAt := time.Now()
+	err = utils.ExtractArchive(ctx, releasePath, releaseDir, publishProgress(eventExtractProgress, map[string]interface{}{
+		"appId": appId,
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to extract archive: %w", err)
+	}
+	metrics.ExtractDuration.Set(time.Since(startedAt).Seconds(), appId.String(), localReleaseVersion)
+	analytics.RecordExtract(time.Since(startedAt))
+
+	return nil
+}
+
+// runLocalSession installs and runs the local release as the synthesised session without contacting the API
+func runLocalSession(ctx context.Context, manager *sessionManager) {
+	session := offlineSession.Data()
+	manager.SetSession(session)
+
+	releasePath := cfg().LocalRelease
+	analytics.SetRelease(localReleaseVersion)
+	logrus.Infof("running the local release %s as the session %s", releasePath, session.Id)
+
+	err := SetSessionStatus(ctx, session.Id, session.AppId, "starting")
+	if err != nil {
+		logrus.Errorf("failed to set session status to starting: %s\n", err.Error())
+	}
+
+	releaseDir, err := localReleaseDir(*session.AppId, releasePath)
+	if err != nil {
+		log.Fatalf("failed to find the local release: %s\n", err.Error())
+	}
+
+	err = appHooks.Run(ctx, hooks.PreInstall, newHookEnv(session, *session.AppId, releaseDir))
+	if err != nil {
+		abortSession(ctx, session, err)
+	}
+
+	manager.SetPhase(phaseInstalling)
+
+	err = installLocalRelease(ctx, *session.AppId, releasePath, releaseDir)
+	if err != nil {
+		log.Fatalf("failed to install the local release: %s\n", err.Error())
+	}
+
+	isAppLaunch = true
+
+	manager.runApp(ctx, *session.AppId, releaseDir)
+}
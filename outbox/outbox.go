@@ -0,0 +1,324 @@
+// Package outbox provides the durable on-disk write-ahead log of the messages delivered to the external services, e.g.
+// the session status updates sent to the API. The messages are delivered in order once the service is available again,
+// including after the launcher restart.
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"veverse-pixel-streaming-launcher/utils"
+)
+
+const (
+	logFile    = "messages.jsonl" // Appended messages
+	cursorFile = "delivered"      // Sequence number of the last delivered message
+)
+
+// Message is a single message of the outbox.
+type Message struct {
+	Seq     uint64          `json:"seq"`
+	Kind    string          `json:"kind"`
+	Key     string          `json:"key"` // Deduplication key, a message with the key of a pending message is not appended
+	Time    time.Time       `json:"time"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Handler delivers the message. A permanent error drops the message, other errors keep it for the next delivery.
+type Handler func(ctx context.Context, m Message) error
+
+// permanentError marks the delivery error which is not retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks the delivery error as permanent, e.g. the service has rejected the message.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent checks if the delivery error is permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Outbox keeps the messages on disk until they are delivered by the handler.
+type Outbox struct {
+	dir     string
+	handler Handler
+
+	deliverMu sync.Mutex // Serializes the deliveries so the messages are delivered in order
+
+	mu        sync.Mutex
+	pending   []Message
+	nextSeq   uint64
+	delivered uint64
+}
+
+// Open opens the outbox in the directory, the messages left pending by the previous run are loaded.
+func Open(dir string, handler Handler) (*Outbox, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+
+	o := &Outbox{dir: dir, handler: handler}
+
+	b, err := os.ReadFile(filepath.Join(dir, cursorFile))
+	if err == nil {
+		o.delivered, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse outbox cursor: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read outbox cursor: %w", err)
+	}
+	o.nextSeq = o.delivered + 1
+
+	f, err := os.Open(filepath.Join(dir, logFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return o, nil
+		}
+		return nil, fmt.Errorf("failed to open outbox: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			logrus.Errorf("failed to close outbox: %s\n", err.Error())
+		}
+	}()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var m Message
+		if err = json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			// Skip the message partially written before a crash
+			logrus.Warningf("skipping corrupted outbox message in %s: %s", dir, err.Error())
+			continue
+		}
+
+		if m.Seq >= o.nextSeq {
+			o.nextSeq = m.Seq + 1
+		}
+		if m.Seq > o.delivered {
+			o.pending = append(o.pending, m)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	return o, nil
+}
+
+// Len returns the number of the pending messages.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.pending)
+}
+
+// Size returns the size of the outbox log on disk.
+func (o *Outbox) Size() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	info, err := os.Stat(filepath.Join(o.dir, logFile))
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// Append writes the message to the outbox. The message is not appended if a message with the same key is pending, the
+// pending message is returned instead.
+func (o *Outbox) Append(kind string, key string, payload interface{}) (Message, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to marshal outbox message: %w", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if key != "" {
+		for _, m := range o.pending {
+			if m.Key == key {
+				return m, nil
+			}
+		}
+	}
+
+	m := Message{
+		Seq:     o.nextSeq,
+		Kind:    kind,
+		Key:     key,
+		Time:    time.Now().UTC(),
+		Payload: b,
+	}
+
+	line, err := json.Marshal(m)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to marshal outbox message: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(o.dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to open outbox: %w", err)
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	if err == nil {
+		// The message must survive the launcher crash right after it is appended
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to write outbox: %w", err)
+	}
+
+	o.nextSeq++
+	o.pending = append(o.pending, m)
+
+	return m, nil
+}
+
+// Flush delivers the pending messages in order, stopping at the first message failed to be delivered. The messages
+// failed with the permanent error are dropped.
+func (o *Outbox) Flush(ctx context.Context) error {
+	_, err := o.flush(ctx, 0)
+	return err
+}
+
+// Deliver appends the message and delivers the pending messages. The message stays in the outbox if it can not be
+// delivered now, only the permanent delivery error of the message is returned.
+func (o *Outbox) Deliver(ctx context.Context, kind string, key string, payload interface{}) error {
+	m, err := o.Append(kind, key, payload)
+	if err != nil {
+		return err
+	}
+
+	permanent, err := o.flush(ctx, m.Seq)
+	if permanent != nil {
+		return permanent
+	}
+	if err != nil {
+		logrus.Warningf("failed to deliver %s, will retry: %s", kind, err.Error())
+	}
+
+	return nil
+}
+
+// Run delivers the pending messages periodically until the context is cancelled.
+func (o *Outbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if o.Len() == 0 {
+			continue
+		}
+
+		err := o.Flush(ctx)
+		if err != nil {
+			logrus.Debugf("failed to flush outbox %s: %s", o.dir, err.Error())
+		}
+	}
+}
+
+// flush delivers the pending messages, the permanent error of the message with the seq is returned separately
+func (o *Outbox) flush(ctx context.Context, seq uint64) (permanent error, err error) {
+	o.deliverMu.Lock()
+	defer o.deliverMu.Unlock()
+
+	for {
+		o.mu.Lock()
+		if len(o.pending) == 0 {
+			o.mu.Unlock()
+			return permanent, o.compact()
+		}
+		m := o.pending[0]
+		o.mu.Unlock()
+
+		err = o.handler(ctx, m)
+		if err != nil && !IsPermanent(err) {
+			return permanent, err
+		}
+
+		if err != nil {
+			logrus.Errorf("dropping undeliverable %s message %d: %s\n", m.Kind, m.Seq, err.Error())
+			if m.Seq == seq {
+				permanent = err
+			}
+		}
+
+		err = o.markDelivered(m.Seq)
+		if err != nil {
+			return permanent, err
+		}
+	}
+}
+
+// markDelivered moves the cursor past the delivered message
+func (o *Outbox) markDelivered(seq uint64) error {
+	err := utils.WriteFileAtomic(filepath.Join(o.dir, cursorFile), []byte(strconv.FormatUint(seq, 10)))
+	if err != nil {
+		return fmt.Errorf("failed to write outbox cursor: %w", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.delivered = seq
+	if len(o.pending) > 0 && o.pending[0].Seq == seq {
+		o.pending = o.pending[1:]
+	}
+
+	return nil
+}
+
+// compact removes the log once all the messages are delivered, the cursor keeps the sequence numbers growing
+func (o *Outbox) compact() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.pending) > 0 {
+		return nil
+	}
+
+	err := os.Remove(filepath.Join(o.dir, logFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to compact outbox: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	sm "dev.hackerman.me/artheon/veverse-shared/model"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"veverse-pixel-streaming-launcher/api"
+	"veverse-pixel-streaming-launcher/system"
+)
+
+// prefetchKey marks the context of the release installation run by the prefetcher
+type prefetchKey struct{}
+
+// releasePrefetcher downloads and installs the latest releases of the hot apps into the apps directory while the launcher
+// waits for a session, so the session does not wait for the download. The prefetch is cancelled before a session is
+// claimed and is resumed if the claim fails.
+type releasePrefetcher struct {
+	ctx context.Context
+
+	mu     sync.Mutex
+	cancel context.CancelFunc // Nil while the prefetch is cancelled
+	done   chan struct{}      // Closed once the last prefetch run has returned
+}
+
+// startPrefetcher starts prefetching the releases until the prefetcher is stopped, nil is returned if the prefetch is disabled
+func startPrefetcher(ctx context.Context) *releasePrefetcher {
+	if !cfg().Prefetch.Enabled {
+		return nil
+	}
+
+	p := &releasePrefetcher{
+		ctx: context.WithValue(ctx, prefetchKey{}, true),
+	}
+	p.Resume()
+
+	return p
+}
+
+// Cancel cancels the prefetch without waiting for the release being installed to be abandoned, nil-safe
+func (p *releasePrefetcher) Cancel() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+}
+
+// Resume prefetches the releases again after the prefetch has been cancelled, once the cancelled run has returned, nil-safe
+func (p *releasePrefetcher) Resume() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	previous, done := p.done, make(chan struct{})
+	p.cancel, p.done = cancel, done
+
+	go func() {
+		defer close(done)
+		if previous != nil {
+			<-previous
+		}
+		p.run(ctx)
+	}()
+}
+
+// Wait waits for the cancelled prefetch to abandon the release being installed, nil-safe
+func (p *releasePrefetcher) Wait() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	done := p.done
+	p.mu.Unlock()
+
+	<-done
+}
+
+// Stop cancels the prefetch and waits for the release being installed to be abandoned, nil-safe
+func (p *releasePrefetcher) Stop() {
+	p.Cancel()
+	p.Wait()
+}
+
+// run prefetches the releases at the prefetch interval until the context is cancelled
+func (p *releasePrefetcher) run(ctx context.Context) {
+	for {
+		p.prefetch(ctx)
+
+		timer := time.NewTimer(cfg().Prefetch.Interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// prefetch installs the latest releases of the hot apps missing in the apps directory one by one
+func (p *releasePrefetcher) prefetch(ctx context.Context) {
+	apps, err := hotApps(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logrus.Warningf("failed to get the apps to prefetch: %s", err.Error())
+		}
+		return
+	}
+
+	for _, appId := range apps {
+		if ctx.Err() != nil {
+			return
+		}
+
+		free, err := system.FreeDisk(".")
+		if err == nil && free < cfg().Prefetch.MinFreeDiskMB*1024*1024 {
+			logrus.Infof("not prefetching the app releases, %d MB of free disk space left", free/1024/1024)
+			return
+		}
+
+		err = prefetchRelease(ctx, appId)
+		if err != nil && ctx.Err() == nil {
+			logrus.Warningf("failed to prefetch the release of the app %s: %s", appId, err.Error())
+		}
+	}
+}
+
+// hotApps returns the configured apps to prefetch or the hot apps reported by the API
+func hotApps(ctx context.Context) ([]uuid.UUID, error) {
+	c := cfg().Prefetch
+	if len(c.Apps) == 0 {
+		return GetHotApps(ctx, c.MaxApps)
+	}
+
+	var apps []uuid.UUID
+	for _, id := range c.Apps {
+		appId, err := uuid.FromString(id)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, appId)
+	}
+
+	return apps, nil
+}
+
+// prefetchRelease installs the latest release of the app unless it is installed already. The release files are not
+// prefetched, as their installation can not be verified, the partially installed release archive is removed.
+func prefetchRelease(ctx context.Context, appId uuid.UUID) error {
+	release, err := api.GetLatestReleaseV2(ctx, appId)
+	if err != nil {
+		return err
+	}
+
+	if releaseInstalled(appId, release) {
+		logrus.Debugf("the release %s of the app %s is prefetched already", release.Version, appId)
+		return nil
+	}
+	if !release.Archive || release.Files == nil || len(release.Files.Entities) == 0 {
+		logrus.Debugf("the release %s of the app %s is not an archive, skipping", release.Version, appId)
+		return nil
+	}
+
+	// The release takes at least the size of its archive
+	dir := appReleaseDir(appId, release)
+	var required uint64
+	for _, file := range release.Files.Entities {
+		if file.Type == "release-archive" && file.Size != nil {
+			required = uint64(*file.Size)
+		}
+	}
+	if !evictReleases(required, dir) {
+		logrus.Infof("not prefetching the release %s of the app %s, it does not fit into the release cache", release.Version, appId)
+		return nil
+	}
+
+	logrus.Infof("prefetching the release %s of the app %s", release.Version, appId)
+	startedAt := time.Now()
+
+	err = installAppReleaseArchive(ctx, appId, *release)
+	if err != nil {
+		err1 := os.RemoveAll(dir)
+		if err1 != nil {
+			logrus.Errorf("failed to remove the partially prefetched release: %s\n", err1.Error())
+		}
+		return err
+	}
+
+	logrus.Infof("prefetched the release %s of the app %s in %s", release.Version, appId, time.Since(startedAt).Round(time.Second))
+
+	// The extracted release may take more space than its archive
+	evictReleases(0, dir)
+
+	return nil
+}
+
+// installedRelease is the release installed in the apps directory
+type installedRelease struct {
+	dir      string
+	size     uint64
+	usedAt   time.Time // Time the release has been installed or last used by a session at
+	complete bool      // The release has the manifest written once it has been installed completely
+}
+
+// installedReleases lists the releases installed in the apps directory with their sizes and last use times
+func installedReleases() []installedRelease {
+	var releases []installedRelease
+	for _, r := range cachedReleases() {
+		dir := filepath.Join(cfg().Dirs.Apps, r.AppId, r.Release)
+
+		// The release without the manifest has not been installed completely or has been installed by an older launcher
+		info, err := os.Stat(filepath.Join(dir, releaseManifestFile))
+		complete := err == nil
+		if !complete {
+			info, err = os.Stat(dir)
+		}
+		if err != nil {
+			logrus.Errorf("failed to get the release directory %s: %s\n", dir, err.Error())
+			continue
+		}
+
+		var size uint64
+		err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += uint64(fi.Size())
+
+			return nil
+		})
+		if err != nil {
+			logrus.Errorf("failed to get the size of the release directory %s: %s\n", dir, err.Error())
+			continue
+		}
+
+		releases = append(releases, installedRelease{dir: dir, size: size, usedAt: info.ModTime(), complete: complete})
+	}
+
+	return releases
+}
+
+// evictReleases removes the installed releases without the manifest, which are never reused, and then the least recently
+// used releases until they take no more than the release cache size with the required bytes. The kept release is never
+// removed. Returns false if the required bytes do not fit.
+func evictReleases(required uint64, keep string) bool {
+	limit := cfg().Prefetch.MaxCacheMB * 1024 * 1024
+
+	var releases []installedRelease
+	for _, r := range installedReleases() {
+		if r.complete || r.dir == keep {
+			releases = append(releases, r)
+			continue
+		}
+
+		logrus.Infof("evicting the incomplete release %s", r.dir)
+		if !removeRelease(r.dir) {
+			releases = append(releases, r)
+		}
+	}
+
+	if limit == 0 {
+		return true
+	}
+
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].usedAt.Before(releases[j].usedAt)
+	})
+
+	var total uint64
+	for _, r := range releases {
+		total += r.size
+	}
+
+	for _, r := range releases {
+		if total+required <= limit {
+			break
+		}
+		if r.dir == keep {
+			continue
+		}
+
+		logrus.Infof("evicting the release %s last used at %s", r.dir, r.usedAt.Format(time.RFC3339))
+		if removeRelease(r.dir) {
+			total -= r.size
+		}
+	}
+
+	return total+required <= limit
+}
+
+// removeRelease removes the installed release and its app directory once it is empty, returns false if the release has
+// not been removed
+func removeRelease(dir string) bool {
+	err := os.RemoveAll(dir)
+	if err != nil {
+		logrus.Errorf("failed to evict the release %s: %s\n", dir, err.Error())
+		return false
+	}
+
+	// The app directory is removed with its last release
+	_ = os.Remove(filepath.Dir(dir))
+
+	return true
+}
+
+// releaseInstalled checks if the app release has been installed completely from the current release files and none of
+// its files has changed since, the release failing the check is removed to be installed again
+func releaseInstalled(appId uuid.UUID, release *sm.ReleaseV2) bool {
+	dir := appReleaseDir(appId, release)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return false
+	}
+
+	err := verifyReleaseManifest(dir, release)
+	if err == nil {
+		return true
+	}
+
+	logrus.Warningf("the installed release %s of the app %s can not be reused: %s", release.Version, appId, err.Error())
+	err = os.RemoveAll(dir)
+	if err != nil {
+		logrus.Errorf("failed to remove the installed release: %s\n", err.Error())
+	}
+
+	return false
+}
+
+// isPrefetch checks if the release is installed by the prefetcher, the prefetch is throttled and is not a part of the
+// session analytics
+func isPrefetch(ctx context.Context) bool {
+	prefetch, _ := ctx.Value(prefetchKey{}).(bool)
+	return prefetch
+}
+
+// throttleDownload delays the prefetch download to keep its average rate under the rate limit
+func throttleDownload(ctx context.Context, startedAt time.Time, downloaded uint64) {
+	limit := cfg().Prefetch.RateLimitKBps * 1024
+	if limit == 0 {
+		return
+	}
+
+	wait := time.Duration(float64(downloaded)/float64(limit)*float64(time.Second)) - time.Since(startedAt)
+	if wait <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
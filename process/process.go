@@ -0,0 +1,102 @@
+// Package process provides resource limits and resource usage accounting for the launched app process.
+package process
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrStartTimeNotSupported is returned by StartTime on the platforms the process start time is not available on, the
+// process can not be told apart from a later process reusing its pid there.
+var ErrStartTimeNotSupported = errors.New("process start time is not supported on this platform")
+
+// Limits describes optional resource limits applied to the app process, zero values mean no limit.
+type Limits struct {
+	MemoryBytes uint64 // Maximum resident memory of the process, in bytes
+	CPUWeight   uint64 // Relative CPU share of the process in range 1-10000 (100 is the default weight of other processes)
+	OpenFiles   uint64 // Maximum number of open file descriptors
+}
+
+// IsZero reports whether no limits are set.
+func (l Limits) IsZero() bool {
+	return l.MemoryBytes == 0 && l.CPUWeight == 0 && l.OpenFiles == 0
+}
+
+// Stats is a single sample of the process resource usage.
+type Stats struct {
+	Pid        int       `json:"pid"`
+	RSSBytes   uint64    `json:"rssBytes"`
+	CPUSeconds float64   `json:"cpuSeconds"`
+	CPUPercent float64   `json:"cpuPercent"`
+	OpenFiles  int       `json:"openFiles"`
+	SampledAt  time.Time `json:"sampledAt"`
+}
+
+// Sampler periodically samples the process resource usage and keeps the latest sample.
+type Sampler struct {
+	pid      int
+	interval time.Duration
+	onSample func(stats Stats)
+
+	mu     sync.RWMutex
+	latest *Stats
+}
+
+// NewSampler creates a new Sampler for the process, the onSample callback is called for every successful sample.
+func NewSampler(pid int, interval time.Duration, onSample func(stats Stats)) *Sampler {
+	return &Sampler{
+		pid:      pid,
+		interval: interval,
+		onSample: onSample,
+	}
+}
+
+// Run samples the process until the context is cancelled.
+func (s *Sampler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.RLock()
+		previous := s.latest
+		s.mu.RUnlock()
+
+		stats, err := Sample(s.pid, previous)
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		s.latest = &stats
+		s.mu.Unlock()
+
+		if s.onSample != nil {
+			s.onSample(stats)
+		}
+	}
+}
+
+// Latest returns the latest sample or nil if the process has not been sampled yet.
+func (s *Sampler) Latest() *Stats {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.latest == nil {
+		return nil
+	}
+
+	stats := *s.latest
+	return &stats
+}
@@ -0,0 +1,303 @@
+//go:build linux
+
+package process
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// cgroupRoot is the mount point of the unified cgroup v2 hierarchy
+	cgroupRoot = "/sys/fs/cgroup"
+	// clockTicks is the USER_HZ value used by the kernel to report process times in /proc
+	clockTicks = 100
+)
+
+// StartLimited starts the command with the resource limits applied before the process runs its first instruction: the
+// process is traced, so it stops right after exec, its rlimits are set and it is moved into a dedicated cgroup v2 group,
+// then it is resumed. The cgroup is used for the memory and CPU limits when available, rlimits are used otherwise.
+// The returned cleanup function removes the cgroup and must be called after the process has exited.
+func StartLimited(cmd *exec.Cmd, name string, limits Limits) (cleanup func(), err error) {
+	cleanup = func() {}
+
+	if limits.IsZero() {
+		return cleanup, cmd.Start()
+	}
+
+	var dir string
+	if limits.MemoryBytes > 0 || limits.CPUWeight > 0 {
+		dir, err = createCgroup(name, limits)
+		if err != nil {
+			logrus.Warningf("cgroup v2 is not available, falling back to rlimits: %s", err.Error())
+			dir = ""
+		}
+	}
+	if dir != "" {
+		cleanup = func() {
+			if err := os.Remove(dir); err != nil {
+				logrus.Errorf("failed to remove cgroup %s: %s", dir, err.Error())
+			}
+		}
+	}
+
+	// The tracer is the thread which has started the process, so the thread is locked until the process is resumed
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Ptrace = true
+
+	err = cmd.Start()
+	if err != nil {
+		cleanup()
+		return func() {}, err
+	}
+
+	pid := cmd.Process.Pid
+
+	// The traced process stops with SIGTRAP once exec has succeeded
+	var status unix.WaitStatus
+	_, err = unix.Wait4(pid, &status, 0, nil)
+	if err == nil && !status.Stopped() {
+		err = fmt.Errorf("unexpected process status %d", status)
+	}
+	if err == nil {
+		err = setLimits(pid, dir, limits)
+	}
+	if err != nil {
+		// The process has not run yet, it is killed instead of running without the limits
+		_ = cmd.Process.Kill()
+		_ = unix.PtraceDetach(pid)
+		_ = cmd.Wait()
+		cleanup()
+		return func() {}, err
+	}
+
+	err = unix.PtraceDetach(pid)
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		cleanup()
+		return func() {}, fmt.Errorf("failed to resume the process: %w", err)
+	}
+
+	return cleanup, nil
+}
+
+// setLimits sets the rlimits of the stopped process and moves it into the cgroup, the memory limit is set as an rlimit
+// without the cgroup
+func setLimits(pid int, dir string, limits Limits) error {
+	if limits.OpenFiles > 0 {
+		err := unix.Prlimit(pid, unix.RLIMIT_NOFILE, &unix.Rlimit{Cur: limits.OpenFiles, Max: limits.OpenFiles}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to set open files limit: %w", err)
+		}
+	}
+
+	if dir != "" {
+		err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+		if err != nil {
+			return fmt.Errorf("failed to move the process into the cgroup: %w", err)
+		}
+		return nil
+	}
+
+	if limits.MemoryBytes > 0 {
+		err := unix.Prlimit(pid, unix.RLIMIT_AS, &unix.Rlimit{Cur: limits.MemoryBytes, Max: limits.MemoryBytes}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to set memory limit: %w", err)
+		}
+	}
+
+	if limits.CPUWeight > 0 {
+		logrus.Warningf("cpu weight can not be applied without cgroup v2")
+	}
+
+	return nil
+}
+
+// createCgroup creates a cgroup v2 group with the memory and CPU limits, the group is removed if it can not be configured
+func createCgroup(name string, limits Limits) (dir string, err error) {
+	if _, err = os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup v2 hierarchy is not mounted: %w", err)
+	}
+
+	// Enable the controllers for the child groups
+	err = os.WriteFile(filepath.Join(cgroupRoot, "cgroup.subtree_control"), []byte("+memory +cpu"), 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to enable cgroup controllers: %w", err)
+	}
+
+	dir = filepath.Join(cgroupRoot, name)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			if err1 := os.Remove(dir); err1 != nil {
+				logrus.Errorf("failed to remove cgroup %s: %s", dir, err1.Error())
+			}
+		}
+	}()
+
+	if limits.MemoryBytes > 0 {
+		err = os.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatUint(limits.MemoryBytes, 10)), 0644)
+		if err != nil {
+			return "", fmt.Errorf("failed to set memory limit: %w", err)
+		}
+
+		// Do not let the process escape the memory limit into the swap
+		err = os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0644)
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to set swap limit: %w", err)
+		}
+		err = nil
+	}
+
+	if limits.CPUWeight > 0 {
+		err = os.WriteFile(filepath.Join(dir, "cpu.weight"), []byte(strconv.FormatUint(limits.CPUWeight, 10)), 0644)
+		if err != nil {
+			return "", fmt.Errorf("failed to set cpu weight: %w", err)
+		}
+	}
+
+	return dir, nil
+}
+
+// Sample reads the process resource usage from /proc, the previous sample is used to calculate the CPU usage percent.
+func Sample(pid int, previous *Stats) (Stats, error) {
+	stats := Stats{Pid: pid, SampledAt: time.Now()}
+
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return stats, fmt.Errorf("failed to read process stat: %w", err)
+	}
+
+	// The process name may contain spaces, so the fields are parsed after its closing parenthesis
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return stats, fmt.Errorf("failed to parse process stat")
+	}
+
+	// Fields starting with the 3rd one (state), utime and stime are the 14th and 15th fields
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 13 {
+		return stats, fmt.Errorf("failed to parse process stat")
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return stats, fmt.Errorf("failed to parse process utime: %w", err)
+	}
+
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return stats, fmt.Errorf("failed to parse process stime: %w", err)
+	}
+
+	stats.CPUSeconds = float64(utime+stime) / clockTicks
+
+	if previous != nil && previous.Pid == pid {
+		elapsed := stats.SampledAt.Sub(previous.SampledAt).Seconds()
+		if elapsed > 0 {
+			stats.CPUPercent = (stats.CPUSeconds - previous.CPUSeconds) / elapsed * 100
+		}
+	}
+
+	stats.RSSBytes, err = readRSS(pid)
+	if err != nil {
+		return stats, err
+	}
+
+	fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err == nil {
+		stats.OpenFiles = len(fds)
+	}
+
+	return stats, nil
+}
+
+// Alive reports whether the process is running.
+func Alive(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
+
+// StartTime returns the process start time in clock ticks since boot, it tells the process apart from a later process
+// reusing its pid.
+func StartTime(pid int) (uint64, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read process stat: %w", err)
+	}
+
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return 0, fmt.Errorf("failed to parse process stat")
+	}
+
+	// starttime is the 22nd field
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("failed to parse process stat")
+	}
+
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse process starttime: %w", err)
+	}
+
+	return startTime, nil
+}
+
+// readRSS reads the resident set size of the process from /proc
+func readRSS(pid int) (uint64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read process status: %w", err)
+	}
+
+	defer func(f *os.File) {
+		if err1 := f.Close(); err1 != nil {
+			logrus.Errorf("failed to close file: %s", err1)
+		}
+	}(f)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+
+		// VmRSS:    123456 kB
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			break
+		}
+
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse process rss: %w", err)
+		}
+
+		return kb * 1024, nil
+	}
+
+	return 0, scanner.Err()
+}
@@ -0,0 +1,43 @@
+//go:build !linux && !windows
+
+package process
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// StartLimited starts the command, the resource limits are not supported on this platform and are ignored.
+func StartLimited(cmd *exec.Cmd, _ string, limits Limits) (cleanup func(), err error) {
+	if !limits.IsZero() {
+		logrus.Warningf("process resource limits are supported on linux only")
+	}
+
+	return func() {}, cmd.Start()
+}
+
+// Sample is not supported on this platform.
+func Sample(_ int, _ *Stats) (Stats, error) {
+	return Stats{}, fmt.Errorf("process sampling is supported on linux only")
+}
+
+// Alive reports whether the process is running, the process is signalled with the null signal.
+func Alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	defer p.Release()
+
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// StartTime is not supported on this platform.
+func StartTime(_ int) (uint64, error) {
+	return 0, ErrStartTimeNotSupported
+}
@@ -0,0 +1,64 @@
+//go:build windows
+
+package process
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows"
+	"os/exec"
+)
+
+// stillActive is the exit code reported for the process which has not exited yet (STILL_ACTIVE)
+const stillActive = 259
+
+// StartLimited starts the command, the resource limits are not supported on this platform and are ignored.
+func StartLimited(cmd *exec.Cmd, _ string, limits Limits) (cleanup func(), err error) {
+	if !limits.IsZero() {
+		logrus.Warningf("process resource limits are supported on linux only")
+	}
+
+	return func() {}, cmd.Start()
+}
+
+// Sample is not supported on this platform.
+func Sample(_ int, _ *Stats) (Stats, error) {
+	return Stats{}, fmt.Errorf("process sampling is supported on linux only")
+}
+
+// Alive reports whether the process is running. The process object outlives the process while any handle to it is
+// open, so the exit code is checked instead of the process existence.
+func Alive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// The process does not exist or belongs to another user, it can not be the app started by the launcher then
+		return false
+	}
+	defer windows.CloseHandle(h)
+
+	var code uint32
+	err = windows.GetExitCodeProcess(h, &code)
+	if err != nil {
+		return false
+	}
+
+	return code == stillActive
+}
+
+// StartTime returns the process creation time in 100-nanosecond intervals since January 1, 1601 (UTC), it tells the
+// process apart from a later process reusing its pid.
+func StartTime(pid int) (uint64, error) {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open process: %w", err)
+	}
+	defer windows.CloseHandle(h)
+
+	var creation, exit, kernel, user windows.Filetime
+	err = windows.GetProcessTimes(h, &creation, &exit, &kernel, &user)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get process times: %w", err)
+	}
+
+	return uint64(creation.HighDateTime)<<32 | uint64(creation.LowDateTime), nil
+}
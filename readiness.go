@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net"
+	"time"
+)
+
+// errStartupTimeout is returned when the app does not register as a streamer at the signalling server in time
+var errStartupTimeout = errors.New("startup timeout")
+
+// waitForReadiness polls the local signalling server until the launched app registers as a streamer or the timeout expires
+func waitForReadiness(ctx context.Context, address string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(cfg().Session.ReadinessCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		ready, err := probeSignalling(ctx, address)
+		if err != nil {
+			logrus.Debugf("signalling server at %s is not ready: %s", address, err.Error())
+		} else if ready {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return errStartupTimeout
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeSignalling checks that the signalling server accepts connections and that a streamer is connected to it
+func probeSignalling(ctx context.Context, address string) (bool, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	err = conn.Close()
+	if err != nil {
+		logrus.Errorf("failed to close signalling server connection: %s", err.Error())
+	}
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false, fmt.Errorf("invalid signalling server address %s: %w", address, err)
+	}
+
+	return isStreamerConnected(port)
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tcpEstablished is the ESTABLISHED socket state as reported by /proc/net/tcp
+const tcpEstablished = "01"
+
+// isStreamerConnected checks the kernel socket tables for an established connection to the signalling server streamer port
+func isStreamerConnected(port string) (bool, error) {
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return false, fmt.Errorf("invalid port %s: %w", port, err)
+	}
+
+	suffix := fmt.Sprintf(":%04X", p)
+
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		connected, err := hasEstablishedConnection(table, suffix)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return false, err
+		}
+
+		if connected {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// hasEstablishedConnection scans the socket table for an established connection with the remote address ending with the port suffix
+func hasEstablishedConnection(table string, suffix string) (bool, error) {
+	f, err := os.Open(table)
+	if err != nil {
+		return false, err
+	}
+
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			logrus.Errorf("failed to close file: %v", err)
+		}
+	}(f)
+
+	scanner := bufio.NewScanner(f)
+	// Skip the header line
+	scanner.Scan()
+	for scanner.Scan() {
+		// sl local_address rem_address st ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+
+		if strings.HasSuffix(fields[2], suffix) && fields[3] == tcpEstablished {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,95 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"strconv"
+	"unsafe"
+)
+
+// TCP table constants of GetExtendedTcpTable, see the MIB_TCPROW_OWNER_PID and MIB_TCP6ROW_OWNER_PID layouts
+const (
+	tcpTableOwnerPidConnections = 4 // TCP_TABLE_OWNER_PID_CONNECTIONS
+	mibTcpStateEstab            = 5 // MIB_TCP_STATE_ESTAB
+	tcpRowSize                  = 24
+	tcp6RowSize                 = 56
+)
+
+var procGetExtendedTcpTable = windows.NewLazySystemDLL("iphlpapi.dll").NewProc("GetExtendedTcpTable")
+
+// isStreamerConnected checks the TCP connection tables for an established connection to the signalling server streamer port
+func isStreamerConnected(port string) (bool, error) {
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return false, fmt.Errorf("invalid port %s: %w", port, err)
+	}
+
+	for _, family := range []uint32{windows.AF_INET, windows.AF_INET6} {
+		table, err := extendedTcpTable(family)
+		if err != nil {
+			return false, err
+		}
+
+		if hasEstablishedConnection(table, family, uint16(p)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// extendedTcpTable returns the raw TCP connection table of the address family
+func extendedTcpTable(family uint32) ([]byte, error) {
+	var size uint32
+	for {
+		var buf []byte
+		var ptr uintptr
+		if size > 0 {
+			buf = make([]byte, size)
+			ptr = uintptr(unsafe.Pointer(&buf[0]))
+		}
+
+		r, _, _ := procGetExtendedTcpTable.Call(ptr, uintptr(unsafe.Pointer(&size)), 0, uintptr(family), tcpTableOwnerPidConnections, 0)
+		switch windows.Errno(r) {
+		case 0:
+			return buf, nil
+		case windows.ERROR_INSUFFICIENT_BUFFER:
+			// The table has grown between the calls, retry with the updated size
+			continue
+		default:
+			return nil, fmt.Errorf("failed to get the tcp table: %w", windows.Errno(r))
+		}
+	}
+}
+
+// hasEstablishedConnection scans the TCP table for an established connection with the remote port, the ports are stored
+// in the network byte order
+func hasEstablishedConnection(table []byte, family uint32, port uint16) bool {
+	if len(table) < 4 {
+		return false
+	}
+
+	n := int(binary.LittleEndian.Uint32(table))
+	rowSize, stateOffset, remotePortOffset := tcpRowSize, 0, 16
+	if family == windows.AF_INET6 {
+		rowSize, stateOffset, remotePortOffset = tcp6RowSize, 48, 44
+	}
+
+	for i := 0; i < n; i++ {
+		row := table[4+i*rowSize:]
+		if len(row) < rowSize {
+			break
+		}
+
+		state := binary.LittleEndian.Uint32(row[stateOffset:])
+		remotePort := binary.BigEndian.Uint16(row[remotePortOffset:])
+		if state == mibTcpStateEstab && remotePort == port {
+			return true
+		}
+	}
+
+	return false
+}
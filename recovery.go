@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	sm "dev.hackerman.me/artheon/veverse-shared/model"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sync/atomic"
+	"time"
+	"veverse-pixel-streaming-launcher/hooks"
+	"veverse-pixel-streaming-launcher/metrics"
+	"veverse-pixel-streaming-launcher/process"
+)
+
+// closeReasonLauncherRestart is the session close reason when the session has been orphaned by the launcher restart
+const closeReasonLauncherRestart = "launcher-restart"
+
+// adoptedPollTime is the interval the adopted app process is checked for exit at
+const adoptedPollTime = time.Second
+
+// recoverSession reconciles the session persisted by the previous launcher run with the API. The app process still
+// running is re-adopted, the session which has not started the app yet is returned to be installed again, and the
+// session orphaned by the restart is closed. The process which can not be told apart from a later process reusing its
+// pid is neither adopted nor killed.
+func recoverSession(ctx context.Context, manager *sessionManager) (resume *sm.PixelStreamingSessionData, adopted bool) {
+	state, err := loadSessionState()
+	if err != nil {
+		logrus.Errorf("%s\n", err.Error())
+		return nil, false
+	}
+	if state == nil || state.SessionId == nil {
+		return nil, false
+	}
+
+	var alive, unverified bool
+	if state.Pid > 0 {
+		alive, err = appProcessAlive(state.Pid, state.StartTime)
+		if err != nil {
+			logrus.Warningf("leaving the process %d running, it can not be verified to be the application: %s", state.Pid, err.Error())
+			unverified = true
+		}
+	}
+
+	session, err := GetSessionData(ctx, state.SessionId)
+	if err != nil {
+		// Without the API the local state is the only source of truth
+		logrus.Errorf("failed to get the data of the session %s: %s\n", state.SessionId, err.Error())
+		session = nil
+	}
+	if session == nil || session.Id == nil {
+		session = &sm.PixelStreamingSessionData{Id: state.SessionId, AppId: state.AppId}
+	}
+
+	switch {
+	case session.Status == "closed" || session.Status == "failed":
+		logrus.Infof("the session %s has ended while the launcher was down", session.Id)
+		if alive {
+			killAppProcess(state.Pid)
+		}
+	case alive && !unverified:
+		logrus.Infof("re-adopting the application process %d of the session %s", state.Pid, session.Id)
+		// The session limits are measured from the claim by the previous launcher run
+		claimedAt := state.ClaimedAt
+		if claimedAt.IsZero() {
+			claimedAt = time.Now()
+		}
+		manager.RestoreSession(session, claimedAt)
+		go manager.adoptApp(ctx, adoptedProcess{pid: state.Pid, startTime: state.StartTime}, state.ReleaseDir)
+		return nil, true
+	case state.Pid == 0 && (state.Phase == phaseIdle || state.Phase == phaseInstalling):
+		logrus.Infof("resuming the installation of the session %s", session.Id)
+		return session, false
+	default:
+		logrus.Infof("closing the session %s orphaned by the launcher restart", session.Id)
+		err = SetSessionStatusWithReason(ctx, session.Id, session.AppId, "closed", closeReasonLauncherRestart)
+		if err != nil {
+			logrus.Errorf("failed to close the orphaned session: %s\n", err.Error())
+		}
+	}
+
+	err = os.RemoveAll(sessionSandboxDir(session.Id))
+	if err != nil {
+		logrus.Errorf("failed to remove the sandbox of the session %s: %s\n", session.Id, err.Error())
+	}
+
+	err = removeSessionState()
+	if err != nil {
+		logrus.Errorf("%s\n", err.Error())
+	}
+
+	return nil, false
+}
+
+// appProcessAlive checks that the app process is still running and its pid has not been reused by another process. The
+// error is returned if the process is running but the start time to tell it apart from another process is not available.
+func appProcessAlive(pid int, startTime uint64) (bool, error) {
+	if !process.Alive(pid) {
+		return false, nil
+	}
+
+	if startTime == 0 {
+		return false, fmt.Errorf("the start time of the process has not been recorded")
+	}
+
+	current, err := process.StartTime(pid)
+	if err != nil {
+		if errors.Is(err, process.ErrStartTimeNotSupported) {
+			return false, err
+		}
+		// The process has exited since checked
+		return false, nil
+	}
+
+	return current == startTime, nil
+}
+
+// killAppProcess kills the app process left running by the previous launcher run
+func killAppProcess(pid int) {
+	proc, err := os.FindProcess(pid)
+	if err == nil {
+		err = proc.Kill()
+		_ = proc.Release()
+	}
+	if err != nil {
+		logrus.Errorf("failed to kill the application process %d: %s\n", pid, err.Error())
+	}
+}
+
+// adoptedProcess is the app process left running by the previous launcher run. The process handle is not held, so the
+// process is found by its pid and verified by its start time for every signal.
+type adoptedProcess struct {
+	pid       int
+	startTime uint64
+}
+
+// PID returns the process id
+func (p adoptedProcess) PID() int {
+	return p.pid
+}
+
+// Signal sends the signal to the process unless it has exited
+func (p adoptedProcess) Signal(sig os.Signal) error {
+	alive, err := appProcessAlive(p.pid, p.startTime)
+	if err != nil {
+		return err
+	}
+	if !alive {
+		return os.ErrProcessDone
+	}
+
+	proc, err := os.FindProcess(p.pid)
+	if err != nil {
+		return err
+	}
+	defer proc.Release()
+
+	return proc.Signal(sig)
+}
+
+// Alive checks that the process is still running
+func (p adoptedProcess) Alive() bool {
+	alive, _ := appProcessAlive(p.pid, p.startTime)
+	return alive
+}
+
+// adoptApp supervises the app process left running by the previous launcher run until it exits. The output and the
+// control channel of the adopted app are not available and its exit code is unknown.
+func (m *sessionManager) adoptApp(ctx context.Context, proc adoptedProcess, releaseDir string) {
+	session := m.Session()
+
+	appCtx, appCancel := context.WithCancel(context.Background())
+	defer appCancel()
+
+	sampler := newAppSampler(ctx, session, proc.pid)
+	go sampler.Run(appCtx)
+
+	watchdog := newSessionWatchdog(m.ClaimedAt(), cfg().Session.MaxDuration, cfg().Session.IdleTimeout, cfg().Session.StopWarning, m.liveness)
+
+	m.mu.Lock()
+	m.proc = proc
+	m.appCtx = appCtx
+	m.sampler = sampler
+	m.watchdog = watchdog
+	m.releaseDir = releaseDir
+	m.startTime = proc.startTime
+	m.mu.Unlock()
+
+	m.SetPhase(phaseStarting)
+
+	var startupFailed atomic.Bool
+	go m.superviseApp(ctx, appCtx, proc, watchdog, nil, &startupFailed)
+
+	// The adopted process is not a child of the launcher, so it can not be waited for
+	ticker := time.NewTicker(adoptedPollTime)
+	for proc.Alive() {
+		<-ticker.C
+	}
+	ticker.Stop()
+	appCancel()
+
+	exitCode := -1
+	launcherEvents.Publish(eventProcessExited, map[string]interface{}{
+		"sessionId": session.Id,
+		"pid":       proc.pid,
+		"exitCode":  exitCode,
+	})
+
+	hookEnv := newHookEnv(session, *session.AppId, releaseDir)
+	hookEnv.ExitCode = &exitCode
+	// A failed post-session hook configured to abort the session becomes the session close reason
+	closeReason := runPostSessionHooks(hooks.PostExit, hookEnv)
+
+	err := os.RemoveAll(sessionSandboxDir(session.Id))
+	if err != nil {
+		logrus.Errorf("failed to remove the sandbox of the session %s: %s\n", session.Id, err.Error())
+	}
+
+	if reason := runPostSessionHooks(hooks.PostCleanup, hookEnv); closeReason == "" {
+		closeReason = reason
+	}
+
+	m.mu.Lock()
+	stopReason := m.stopReason
+	restart := m.restartRequested && stopReason == ""
+	m.restartRequested = false
+	if restart {
+		m.restarts++
+	} else {
+		m.phase = phaseClosed
+	}
+	m.mu.Unlock()
+
+	if restart {
+		logrus.Infof("the adopted application has been stopped for restart")
+		metrics.AppRestarts.Inc()
+		analytics.RecordRestart()
+		m.runApp(ctx, *session.AppId, releaseDir)
+		return
+	}
+
+	m.clearState()
+
+	switch {
+	case startupFailed.Load():
+		analytics.Finish("failed", errStartupTimeout.Error(), exitCode)
+	case stopReason != "":
+		logrus.Infof("the adopted application has been stopped by the launcher (%s)", stopReason)
+		analytics.Finish("closed", stopReason, exitCode)
+	default:
+		logrus.Infof("the adopted application has exited")
+		analytics.Finish("closed", closeReason, exitCode)
+		err = SetSessionStatusWithReason(ctx, session.Id, session.AppId, "closed", closeReason)
+		if err != nil {
+			logrus.Errorf("failed to set session status to closed: %s\n", err.Error())
+		}
+	}
+}
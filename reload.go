@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"veverse-pixel-streaming-launcher/config"
+)
+
+// configPollTime is the interval of the configuration file change checks
+const configPollTime = 5 * time.Second
+
+// currentConfig is the effective launcher configuration, replaced when the configuration is reloaded
+var currentConfig atomic.Pointer[config.Launcher]
+
+// cfg returns the effective launcher configuration
+func cfg() *config.Launcher {
+	return currentConfig.Load()
+}
+
+// configState is the configuration reload state reported by the status endpoint
+type configState struct {
+	Path        string    `json:"path,omitempty"`
+	LoadedAt    time.Time `json:"loadedAt"`
+	Pending     []string  `json:"pending,omitempty"`     // Changed settings waiting for the launcher restart
+	NextSession []string  `json:"nextSession,omitempty"` // Changed settings waiting for the next app start
+	Error       string    `json:"error,omitempty"`       // Last reload error, the previous configuration is kept
+}
+
+// configReloader reloads the launcher configuration on SIGHUP and when the configuration file changes
+type configReloader struct {
+	source *config.Source
+	apply  func(c *config.Launcher) // Applies the reloaded settings to the running components
+
+	mu      sync.Mutex
+	modTime time.Time
+	state   configState
+	next    *config.Launcher // Reloaded configuration the app and instance settings of which wait for the next app start
+}
+
+// newConfigReloader creates a new configReloader
+func newConfigReloader(source *config.Source, apply func(c *config.Launcher)) *configReloader {
+	r := &configReloader{
+		source: source,
+		apply:  apply,
+		state: configState{
+			Path:     source.Path,
+			LoadedAt: time.Now(),
+		},
+	}
+	r.modTime = r.fileModTime()
+
+	return r
+}
+
+// Run watches for the reload signal and the configuration file changes until the context is cancelled
+func (r *configReloader) Run(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(configPollTime)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			logrus.Infof("reloading the configuration on SIGHUP")
+		case <-ticker.C:
+			if r.source.Path == "" {
+				continue
+			}
+
+			modTime := r.fileModTime()
+
+			r.mu.Lock()
+			changed := !modTime.Equal(r.modTime)
+			r.modTime = modTime
+			r.mu.Unlock()
+
+			if !changed {
+				continue
+			}
+
+			logrus.Infof("reloading the changed configuration file %s", r.source.Path)
+		}
+
+		_ = r.Reload()
+	}
+}
+
+// Reload loads and validates the configuration and applies the settings that can be changed live. An invalid
+// configuration is reported by the status endpoint and the current configuration is kept.
+func (r *configReloader) Reload() error {
+	next, err := r.source.Load()
+	if err == nil {
+		err = next.Validate()
+		if err != nil {
+			err = fmt.Errorf("invalid config: %w", err)
+		}
+	}
+
+	if err != nil {
+		logrus.Errorf("failed to reload the configuration, keeping the current one: %s\n", err.Error())
+
+		r.mu.Lock()
+		r.state.Error = err.Error()
+		r.mu.Unlock()
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, pending, session := config.Merge(cfg(), next)
+	currentConfig.Store(c)
+	r.apply(c)
+
+	if len(pending) > 0 {
+		logrus.Warningf("the changed settings are applied after the launcher restart: %s", strings.Join(pending, ", "))
+	}
+	for _, name := range session {
+		logrus.Infof("the changed %s configuration applies on next session", name)
+	}
+
+	r.next = nil
+	if len(session) > 0 {
+		r.next = next
+	}
+
+	r.state.LoadedAt = time.Now()
+	r.state.Pending = pending
+	r.state.NextSession = session
+	r.state.Error = ""
+
+	return nil
+}
+
+// ApplySession applies the reloaded app and instance settings, it is called right before the app starts so the running
+// app keeps the settings it has been started with
+func (r *configReloader) ApplySession() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next == nil {
+		return
+	}
+
+	logrus.Infof("applying the changed settings on the app start: %s", strings.Join(r.state.NextSession, ", "))
+
+	c := config.MergeSession(cfg(), r.next)
+	currentConfig.Store(c)
+	r.apply(c)
+
+	r.next = nil
+	r.state.NextSession = nil
+}
+
+// State returns the configuration reload state
+func (r *configReloader) State() configState {
+	if r == nil {
+		return configState{}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.state
+}
+
+// fileModTime returns the modification time of the configuration file, zero if the file is missing
+func (r *configReloader) fileModTime() time.Time {
+	if r.source.Path == "" {
+		return time.Time{}
+	}
+
+	info, err := os.Stat(r.source.Path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
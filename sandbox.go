@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultEnvAllowlist lists the environment variables passed to the app unless the allowlist is configured
+var defaultEnvAllowlist = []string{
+	// Linux
+	"PATH", "LANG", "LANGUAGE", "LC_ALL", "TZ", "DISPLAY", "XDG_RUNTIME_DIR", "LD_LIBRARY_PATH", "VK_ICD_FILENAMES",
+	"NVIDIA_VISIBLE_DEVICES", "NVIDIA_DRIVER_CAPABILITIES",
+	// Windows
+	"SYSTEMROOT", "WINDIR", "SYSTEMDRIVE", "COMSPEC", "PATHEXT", "OS", "NUMBER_OF_PROCESSORS", "PROCESSOR_ARCHITECTURE",
+	"PROGRAMFILES", "PROGRAMFILES(X86)", "PROGRAMDATA", "COMMONPROGRAMFILES",
+}
+
+// secretEnvMarkers are the parts of environment variable names considered secret, such variables are never passed to the app
+var secretEnvMarkers = []string{"PASS", "SECRET", "TOKEN", "KEY", "EMAIL", "CLICKHOUSE"}
+
+// appSandbox is the per-session environment of the app: dedicated home and temp directories, filtered environment variables and an optional unprivileged user and group
+type appSandbox struct {
+	dir       string
+	home      string
+	tmp       string
+	allowlist map[string]bool
+	user      string
+	group     string // The user primary group is used if empty
+}
+
+// newAppSandbox creates the sandbox directories for the session, the default allowlist is used if the allowlist is empty
+func newAppSandbox(sessionDir string, allowlist []string, user string, group string) (*appSandbox, error) {
+	dir, err := filepath.Abs(sessionDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the sandbox directory: %w", err)
+	}
+
+	s := &appSandbox{
+		dir:       dir,
+		home:      filepath.Join(dir, "home"),
+		tmp:       filepath.Join(dir, "tmp"),
+		allowlist: map[string]bool{},
+		user:      user,
+		group:     group,
+	}
+
+	if len(allowlist) == 0 {
+		allowlist = defaultEnvAllowlist
+	}
+
+	for _, name := range allowlist {
+		s.allowlist[strings.ToUpper(strings.TrimSpace(name))] = true
+	}
+
+	for _, d := range []string{s.home, s.tmp} {
+		err = os.MkdirAll(d, 0700)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the sandbox directory %s: %w", d, err)
+		}
+	}
+
+	return s, nil
+}
+
+// Environ filters the environment, removing secrets and variables not in the allowlist, and points the home and temp directories to the sandbox
+func (s *appSandbox) Environ(environ []string) []string {
+	var env []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		// Environment variable names are case-insensitive on Windows
+		upper := strings.ToUpper(name)
+		if !s.allowlist[upper] || isSecretEnv(upper) {
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	return append(env,
+		"HOME="+s.home,
+		"USERPROFILE="+s.home,
+		"LOCALAPPDATA="+filepath.Join(s.home, "AppData", "Local"),
+		"TMPDIR="+s.tmp,
+		"TMP="+s.tmp,
+		"TEMP="+s.tmp,
+	)
+}
+
+// Cleanup wipes the sandbox directories after the session
+func (s *appSandbox) Cleanup() {
+	err := os.RemoveAll(s.dir)
+	if err != nil {
+		logrus.Errorf("failed to remove the sandbox directory %s: %s", s.dir, err.Error())
+	}
+}
+
+// isSecretEnv checks if the environment variable name looks like a secret
+func isSecretEnv(name string) bool {
+	for _, marker := range secretEnvMarkers {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Apply configures the command to run as the sandbox user and group. The user gets the ownership of the sandbox
+// directory, the release directory stays owned by the launcher and the group gets read-only access to it.
+func (s *appSandbox) Apply(cmd *exec.Cmd, releaseDir string) error {
+	if s.user == "" {
+		return nil
+	}
+
+	u, err := user.Lookup(s.user)
+	if err != nil {
+		// Try the numeric user id
+		var err1 error
+		u, err1 = user.LookupId(s.user)
+		if err1 != nil {
+			return fmt.Errorf("failed to find the user %s: %w", s.user, err)
+		}
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid uid %s: %w", u.Uid, err)
+	}
+
+	gidStr := u.Gid
+	if s.group != "" {
+		g, err := user.LookupGroup(s.group)
+		if err != nil {
+			// Try the numeric group id
+			var err1 error
+			g, err1 = user.LookupGroupId(s.group)
+			if err1 != nil {
+				return fmt.Errorf("failed to find the group %s: %w", s.group, err)
+			}
+		}
+		gidStr = g.Gid
+	}
+
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid gid %s: %w", gidStr, err)
+	}
+
+	err = chownRecursive(s.dir, int(uid), int(gid))
+	if err != nil {
+		return err
+	}
+
+	err = grantGroupRead(releaseDir, int(gid))
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range []string{s.dir, releaseDir} {
+		err = makeParentsTraversable(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+
+	return nil
+}
+
+// chownRecursive changes the owner of the directory and all its contents
+func chownRecursive(root string, uid int, gid int) error {
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		return os.Lchown(path, uid, gid)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to change the owner of %s: %w", root, err)
+	}
+
+	return nil
+}
+
+// grantGroupRead gives the group read access to the directory and all its contents, keeping the owner. The group gets
+// the owner read and execute permissions, the group write permission is removed, so the shared release can not be
+// modified by the app.
+func grantGroupRead(root string, gid int) error {
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		err = os.Lchown(path, -1, gid)
+		if err != nil {
+			return err
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		mode := info.Mode().Perm()
+		mode = mode&^0070 | mode&0500>>3
+		if mode == info.Mode().Perm() {
+			return nil
+		}
+
+		return os.Chmod(path, mode)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to give the group access to %s: %w", root, err)
+	}
+
+	return nil
+}
+
+// makeParentsTraversable gives the others the search permission on the parents of the directory inside the launcher
+// working directory, so the app user can reach the directory without listing its parents. The parents outside the
+// working directory are not changed.
+func makeParentsTraversable(dir string) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get the working directory: %w", err)
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to get the directory %s: %w", dir, err)
+	}
+
+	for parent := filepath.Dir(dir); parent == wd || strings.HasPrefix(parent, wd+string(filepath.Separator)); parent = filepath.Dir(parent) {
+		info, err := os.Stat(parent)
+		if err != nil {
+			return fmt.Errorf("failed to get the directory %s: %w", parent, err)
+		}
+
+		if info.Mode().Perm()&0001 == 0 {
+			err = os.Chmod(parent, info.Mode().Perm()|0001)
+			if err != nil {
+				return fmt.Errorf("failed to make the directory %s traversable: %w", parent, err)
+			}
+		}
+
+		if parent == wd {
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"os/exec"
+)
+
+// Apply is a no-op on this platform as running the app as a dedicated user is supported on linux only
+func (s *appSandbox) Apply(_ *exec.Cmd, _ string) error {
+	if s.user != "" || s.group != "" {
+		logrus.Warningf("running the application as the %s user and the %s group is supported on linux only", s.user, s.group)
+	}
+
+	return nil
+}
@@ -2,82 +2,317 @@ package main
 
 import (
 	"context"
+	sm "dev.hackerman.me/artheon/veverse-shared/model"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/sirupsen/logrus"
-	"log"
+	"net"
 	"net/http"
-	"os"
-	"strconv"
+	"sort"
+	"strings"
+	"time"
+	"veverse-pixel-streaming-launcher/config"
+	"veverse-pixel-streaming-launcher/events"
+	"veverse-pixel-streaming-launcher/metrics"
 )
 
-var ctx context.Context
+// closeReasonClient is the session close reason when the client has closed the session
+const closeReasonClient = "client-closed"
 
-func startWebServer(ctx context.Context) {
-	http.HandleFunc("/healthcheck", healthCheck)
-	http.HandleFunc("/hello", closeSession)
+// sessionController is the session management used by the control server handlers
+type sessionController interface {
+	Session() *sm.PixelStreamingSessionData
+	Status() launcherStatus
+	HandleLivenessEvent(event livenessEvent) error
+	Close(ctx context.Context, reason string) error
+	Restart() error
+}
+
+// controlServer handles the launcher control API requests from the signalling web server
+type controlServer struct {
+	ctx       context.Context
+	sessions  sessionController
+	auth      *requestAuthenticator
+	events    *events.Broker
+	config    *configReloader
+	heartbeat *instanceHeartbeat
+	drain     *drainController
+	shutdown  context.CancelFunc      // Shuts the launcher down after the session is closed
+	cfg       func() *config.Launcher // Returns the effective launcher configuration
+}
+
+// newControlServer creates a new controlServer
+func newControlServer(ctx context.Context, sessions sessionController, auth *requestAuthenticator, events *events.Broker, config *configReloader, heartbeat *instanceHeartbeat, drain *drainController, shutdown context.CancelFunc, cfg func() *config.Launcher) *controlServer {
+	return &controlServer{
+		ctx:       ctx,
+		sessions:  sessions,
+		auth:      auth,
+		events:    events,
+		config:    config,
+		heartbeat: heartbeat,
+		drain:     drain,
+		shutdown:  shutdown,
+		cfg:       cfg,
+	}
+}
+
+// routes registers the control API endpoints
+func (s *controlServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthcheck", route(map[string]http.HandlerFunc{
+		http.MethodGet: s.healthCheck,
+	}))
+	mux.HandleFunc("/status", route(map[string]http.HandlerFunc{
+		http.MethodGet: s.status,
+	}))
+	mux.HandleFunc("/events", route(map[string]http.HandlerFunc{
+		http.MethodGet: s.streamEvents,
+	}))
+	mux.HandleFunc("/session", route(map[string]http.HandlerFunc{
+		http.MethodGet:    s.getSession,
+		http.MethodDelete: s.closeSession,
+	}))
+	mux.HandleFunc("/session/events", route(map[string]http.HandlerFunc{
+		http.MethodPost: s.sessionEvent,
+	}))
+	mux.HandleFunc("/session/restart", route(map[string]http.HandlerFunc{
+		http.MethodPost: s.restartSession,
+	}))
+	mux.HandleFunc("/drain", route(map[string]http.HandlerFunc{
+		http.MethodGet:  s.getDrain,
+		http.MethodPost: s.startDrain,
+	}))
+
+	// The metrics are scraped by Prometheus which can not sign the requests
+	root := http.NewServeMux()
+	root.Handle("/metrics", route(map[string]http.HandlerFunc{
+		http.MethodGet: metrics.Default.Handler().ServeHTTP,
+	}))
+	root.Handle("/", s.auth.Middleware(mux))
+	return root
+}
+
+// route dispatches the request to the handler registered for the request method
+func route(handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	var allowed []string
+	for method := range handlers {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
 
-	err := http.ListenAndServe(":8080", nil)
-	if err != nil && err != http.ErrServerClosed {
-		logrus.Errorf("failed to start web server: %s\n", err.Error())
-		err = SetSessionStatus(ctx, session.Id, session.AppId, "closed")
-		if err != nil {
-			logrus.Errorf("failed close session: %s\n", err.Error())
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := handlers[r.Method]
+		if !ok {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
 		}
 
-		os.Exit(1)
-		return
+		handler(w, r)
 	}
 }
 
-func healthCheck(w http.ResponseWriter, r *http.Request) {
-	sess, err := GetSessionData(ctx, session.Id)
+// writeJSON writes the JSON response with the status code
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.Marshal(v)
 	if err != nil {
-		logrus.Errorf("failed to get session data: %s\n", err.Error())
+		logrus.Errorf("failed to marshal response: %s\n", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err = w.Write(b); err != nil {
+		logrus.Errorf("failed to write response: %s\n", err.Error())
 	}
+}
+
+// writeError writes the JSON error response with the status code
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{
+		"status":  "error",
+		"message": message,
+	})
+}
+
+// startWebServer starts the control server and shuts it down gracefully when the context is cancelled. A bind failure is
+// returned immediately, a later serve failure is sent to the returned channel, which is closed after the server stops.
+func startWebServer(ctx context.Context, c config.ControlConfig, s *controlServer) (<-chan error, error) {
 
-	t := r.URL.Query().Get("totalCheck")
+	srv := &http.Server{
+		Addr:              c.Address,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: c.ReadTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+	}
 
-	var totalCheck int
-	totalCheck, err = strconv.Atoi(t)
+	ln, err := net.Listen("tcp", srv.Addr)
 	if err != nil {
-		logrus.Errorf("failed to convert totalCheck to int: %s\n", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		return nil, fmt.Errorf("failed to listen on %s: %w", srv.Addr, err)
 	}
 
-	if sess.Status == "running" && totalCheck >= 20 {
-		err = SetSessionStatus(ctx, session.Id, session.AppId, "closed")
-		if err != nil {
-			logrus.Errorf("failed close session: %s\n", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+	errs := make(chan error, 1)
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+		defer shutdownCancel()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logrus.Errorf("failed to shutdown web server: %s\n", err.Error())
 		}
+	}()
 
-		return
-	} else {
-		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Type", "application/json")
-		resp := make(map[string]string)
-		resp["sessionStatus"] = sess.Status
-		var jsonResp []byte
-		jsonResp, err = json.Marshal(resp)
-		if err != nil {
-			log.Fatalf("Error happened in JSON marshal. Err: %s", err)
+	go func() {
+		defer close(errs)
+
+		err := srv.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
+			errs <- err
 		}
+	}()
+
+	return errs, nil
+}
+
+// healthCheck reports the session status to the signalling server
+func (s *controlServer) healthCheck(w http.ResponseWriter, _ *http.Request) {
+	session := s.sessions.Session()
+	if session == nil || session.Id == nil {
+		writeError(w, http.StatusNotFound, errNoSession.Error())
+		return
+	}
 
-		w.Write(jsonResp)
+	sess, err := GetSessionData(s.ctx, session.Id)
+	if err != nil {
+		logrus.Errorf("failed to get session data: %s\n", err.Error())
+		writeError(w, http.StatusBadGateway, "failed to get session data")
 		return
 	}
+
+	status := s.sessions.Status()
+	resp := map[string]interface{}{
+		"sessionStatus": sess.Status,
+		"liveness":      status.Liveness,
+	}
+	if status.Process != nil {
+		resp["process"] = status.Process
+	}
+
+	writeJSON(w, http.StatusOK, resp)
 }
 
-func closeSession(w http.ResponseWriter, r *http.Request) {
-	err := SetSessionStatus(ctx, session.Id, session.AppId, "closed")
+// sessionEvent handles the player connected, disconnected and heartbeat events of the signalling server
+func (s *controlServer) sessionEvent(w http.ResponseWriter, r *http.Request) {
+	var event livenessEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid event")
+		return
+	}
+
+	if err := s.sessions.HandleLivenessEvent(event); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// status reports the launcher status, the configuration reload state, the instance heartbeat and drain state
+func (s *controlServer) status(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, struct {
+		launcherStatus
+		Config    configState    `json:"config"`
+		Heartbeat heartbeatState `json:"heartbeat"`
+		Drain     drainState     `json:"drain"`
+	}{
+		launcherStatus: s.sessions.Status(),
+		Config:         s.config.State(),
+		Heartbeat:      s.heartbeat.State(),
+		Drain:          s.drain.State(),
+	})
+}
+
+// getSession returns the current session data
+func (s *controlServer) getSession(w http.ResponseWriter, _ *http.Request) {
+	session := s.sessions.Session()
+	if session == nil || session.Id == nil {
+		writeError(w, http.StatusNotFound, errNoSession.Error())
+		return
+	}
+
+	sess, err := GetSessionData(s.ctx, session.Id)
+	if err != nil {
+		logrus.Errorf("failed to get session data: %s\n", err.Error())
+		writeError(w, http.StatusBadGateway, "failed to get session data")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sess)
+}
+
+// closeSession closes the session when the client has closed it, e.g. the browser tab is closed, and shuts the launcher down
+func (s *controlServer) closeSession(w http.ResponseWriter, _ *http.Request) {
+	err := s.sessions.Close(s.ctx, closeReasonClient)
 	if err != nil {
+		if errors.Is(err, errNoSession) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
 		logrus.Errorf("failed close session: %s\n", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "failed to close session")
+		return
 	}
 
+	w.WriteHeader(http.StatusNoContent)
+
 	// Shutdown the server
-	cancel()
+	s.shutdown()
+}
+
+// restartSession restarts the app of the current session
+func (s *controlServer) restartSession(w http.ResponseWriter, _ *http.Request) {
+	err := s.sessions.Restart()
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// getDrain returns the drain state of the instance
+func (s *controlServer) getDrain(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.drain.State())
+}
+
+// startDrain stops the instance from taking new sessions, the optional timeout overrides the configured drain timeout
+func (s *controlServer) startDrain(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Timeout string `json:"timeout"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid drain request")
+			return
+		}
+	}
+
+	timeout := s.cfg().Instance.DrainTimeout
+	if request.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(request.Timeout)
+		if err != nil || timeout < 0 {
+			writeError(w, http.StatusBadRequest, "invalid drain timeout")
+			return
+		}
+	}
 
-	return
+	writeJSON(w, http.StatusAccepted, s.drain.Drain(s.ctx, timeout))
 }
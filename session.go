@@ -0,0 +1,780 @@
+package main
+
+import (
+	"context"
+	sm "dev.hackerman.me/artheon/veverse-shared/model"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"veverse-pixel-streaming-launcher/config"
+	"veverse-pixel-streaming-launcher/hooks"
+	"veverse-pixel-streaming-launcher/metrics"
+	"veverse-pixel-streaming-launcher/process"
+)
+
+// Session phases of the launcher as reported by the status endpoint
+const (
+	phaseIdle       = "idle"
+	phaseInstalling = "installing"
+	phaseStarting   = "starting"
+	phaseRunning    = "running"
+	phaseStopping   = "stopping"
+	phaseClosed     = "closed"
+)
+
+// phases lists all session phases in the lifecycle order
+var phases = []string{phaseIdle, phaseInstalling, phaseStarting, phaseRunning, phaseStopping, phaseClosed}
+
+var (
+	errNoSession     = errors.New("no session")
+	errAppNotRunning = errors.New("the application is not running")
+)
+
+// launcherStatus is the snapshot of the launcher state reported by the status endpoint
+type launcherStatus struct {
+	InstanceId string         `json:"instanceId"`
+	SessionId  *uuid.UUID     `json:"sessionId,omitempty"`
+	AppId      *uuid.UUID     `json:"appId,omitempty"`
+	Phase      string         `json:"phase"`
+	Pid        int            `json:"pid,omitempty"`
+	Restarts   int            `json:"restarts"`
+	Process    *process.Stats `json:"process,omitempty"`
+	Liveness   livenessState  `json:"liveness"`
+}
+
+// sessionManager owns the current session and its app process, it is shared by the session loop and the control server
+type sessionManager struct {
+	mu               sync.RWMutex
+	session          *sm.PixelStreamingSessionData
+	claimedAt        time.Time
+	phase            string
+	proc             appProcess
+	appCtx           context.Context // Cancelled when the app process exits
+	sampler          *process.Sampler
+	liveness         *livenessTracker
+	watchdog         *sessionWatchdog
+	releaseDir       string
+	startTime        uint64     // Start time of the app process, see process.StartTime
+	stateMu          sync.Mutex // Serializes the session state snapshots written to disk
+	stopReason       string     // Set when the launcher stops the app by itself
+	restartRequested bool
+	restarts         int
+}
+
+// newSessionManager creates a new sessionManager
+func newSessionManager() *sessionManager {
+	return &sessionManager{
+		phase:    phaseIdle,
+		liveness: newLivenessTracker(cfg().Session.HeartbeatTimeout),
+	}
+}
+
+// SetSession sets the current session claimed now
+func (m *sessionManager) SetSession(session *sm.PixelStreamingSessionData) {
+	m.RestoreSession(session, time.Now())
+}
+
+// RestoreSession sets the current session claimed at the time, e.g. the session of the previous launcher run
+func (m *sessionManager) RestoreSession(session *sm.PixelStreamingSessionData, claimedAt time.Time) {
+	m.mu.Lock()
+
+	m.session = session
+	m.claimedAt = claimedAt
+	m.mu.Unlock()
+
+	analytics.Claim(session)
+	m.saveState()
+}
+
+// ClaimedAt returns the time the current session has been claimed at, the session limits are measured from it
+func (m *sessionManager) ClaimedAt() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.claimedAt
+}
+
+// Reset forgets the session closed before its app has started, so the launcher can take another session
+func (m *sessionManager) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.session = nil
+	m.claimedAt = time.Time{}
+	m.phase = phaseIdle
+	m.proc = nil
+	m.appCtx = nil
+	m.sampler = nil
+	m.watchdog = nil
+	m.releaseDir = ""
+	m.startTime = 0
+	m.stopReason = ""
+	m.restartRequested = false
+	m.restarts = 0
+}
+
+// Session returns the current session or nil if there is no session
+func (m *sessionManager) Session() *sm.PixelStreamingSessionData {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.session
+}
+
+// SetPhase updates the current session phase
+func (m *sessionManager) SetPhase(phase string) {
+	m.mu.Lock()
+	m.phase = phase
+	claimedAt := m.claimedAt
+	m.mu.Unlock()
+
+	m.saveState()
+
+	metrics.SetSessionPhase(phase, phases)
+	if phase == phaseRunning && !claimedAt.IsZero() {
+		metrics.TimeToRunning.Set(time.Since(claimedAt).Seconds())
+		analytics.MarkReady()
+	}
+
+	launcherEvents.Publish(eventSessionPhase, map[string]interface{}{
+		"phase": phase,
+	})
+}
+
+// saveState persists the current session, so the launcher can reconcile it after a restart
+func (m *sessionManager) saveState() {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	m.mu.RLock()
+	if m.session == nil || m.session.Id == nil {
+		m.mu.RUnlock()
+		return
+	}
+	state := sessionState{
+		SessionId:  m.session.Id,
+		AppId:      m.session.AppId,
+		Phase:      m.phase,
+		ReleaseDir: m.releaseDir,
+		ClaimedAt:  m.claimedAt,
+	}
+	if m.proc != nil && m.appCtx.Err() == nil {
+		state.Pid = m.proc.PID()
+		state.StartTime = m.startTime
+	}
+	m.mu.RUnlock()
+
+	err := saveSessionState(state)
+	if err != nil {
+		logrus.Errorf("failed to save session state: %s\n", err.Error())
+	}
+}
+
+// clearState removes the persisted session once the session has ended
+func (m *sessionManager) clearState() {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	err := removeSessionState()
+	if err != nil {
+		logrus.Errorf("%s\n", err.Error())
+	}
+}
+
+// Status returns the launcher status snapshot
+func (m *sessionManager) Status() launcherStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := launcherStatus{
+		InstanceId: instanceId,
+		Phase:      m.phase,
+		Restarts:   m.restarts,
+		Process:    m.sampler.Latest(),
+		Liveness:   m.liveness.State(),
+	}
+
+	if m.session != nil {
+		status.SessionId = m.session.Id
+		status.AppId = m.session.AppId
+	}
+
+	if m.proc != nil && m.appCtx.Err() == nil {
+		status.Pid = m.proc.PID()
+	}
+
+	return status
+}
+
+// ApplyConfig applies the reloaded session limits and liveness thresholds to the running session
+func (m *sessionManager) ApplyConfig(c *config.Launcher) {
+	m.liveness.SetHeartbeatTimeout(c.Session.HeartbeatTimeout)
+
+	m.mu.RLock()
+	watchdog := m.watchdog
+	m.mu.RUnlock()
+
+	if watchdog != nil {
+		watchdog.SetLimits(c.Session.MaxDuration, c.Session.IdleTimeout, c.Session.StopWarning)
+	}
+}
+
+// HandleLivenessEvent forwards the player event reported by the signalling server to the liveness tracker
+func (m *sessionManager) HandleLivenessEvent(event livenessEvent) error {
+	return m.liveness.HandleEvent(event)
+}
+
+// Close closes the session with the reason and stops the app if it is running
+func (m *sessionManager) Close(ctx context.Context, reason string) error {
+	m.mu.Lock()
+	session := m.session
+	proc, appCtx := m.proc, m.appCtx
+	running := proc != nil && appCtx.Err() == nil
+	if running {
+		m.phase = phaseStopping
+		if m.stopReason == "" {
+			m.stopReason = reason
+		}
+	} else {
+		m.phase = phaseClosed
+	}
+	m.mu.Unlock()
+
+	if session == nil || session.Id == nil {
+		return errNoSession
+	}
+
+	err := SetSessionStatusWithReason(ctx, session.Id, session.AppId, "closed", reason)
+
+	if running {
+		go stopApp(appCtx, proc, cfg().Session.StopTimeout)
+	}
+
+	return err
+}
+
+// Abandon stops the app if it is running without reporting the session status, as the session is not owned by the
+// instance anymore, e.g. its lease has been lost
+func (m *sessionManager) Abandon(reason string) {
+	m.mu.Lock()
+	proc, appCtx := m.proc, m.appCtx
+	running := proc != nil && appCtx.Err() == nil
+	if m.stopReason == "" {
+		m.stopReason = reason
+	}
+	if running {
+		m.phase = phaseStopping
+	} else {
+		m.phase = phaseClosed
+	}
+	m.mu.Unlock()
+
+	if running {
+		go stopApp(appCtx, proc, cfg().Session.StopTimeout)
+	}
+}
+
+// Restart stops the app process of the current session and starts it again
+func (m *sessionManager) Restart() error {
+	m.mu.Lock()
+	proc, appCtx := m.proc, m.appCtx
+	if proc == nil || appCtx.Err() != nil || m.stopReason != "" {
+		m.mu.Unlock()
+		return errAppNotRunning
+	}
+	m.restartRequested = true
+	m.phase = phaseStopping
+	m.mu.Unlock()
+
+	go stopApp(appCtx, proc, cfg().Session.StopTimeout)
+
+	return nil
+}
+
+// runApp runs the app release for the current session until the app exits, restarting the app on request
+func (m *sessionManager) runApp(ctx context.Context, id uuid.UUID, releaseDir string) {
+	session := m.Session()
+
+	m.mu.Lock()
+	m.releaseDir = releaseDir
+	m.mu.Unlock()
+
+	//region Entrypoint
+
+	entrypoint, err := findEntrypoint(releaseDir)
+	if err != nil || entrypoint == "" {
+		log.Fatalf("failed to find an entrypoint: %s\n", err.Error())
+	}
+
+	projectName := getProjectName(entrypoint)
+
+	// Get the PROJECT_DIR basing on the entrypoint as "../../../"
+	projectDir := path.Dir(path.Dir(path.Dir(path.Dir(entrypoint)))) + "/"
+	// Check if we need to normalize the entrypoint to the PROJECT_DIR
+	if strings.Count(entrypoint, "/") > 3 {
+		// Check if we need to remove excessive path prefix
+		if !strings.HasPrefix(entrypoint, projectName) {
+			// Normalize entrypoint to the PROJECT_DIR by removing excessive prefix
+			entrypoint = strings.Replace(entrypoint, projectDir, "", 1)
+		}
+	}
+
+	log.Printf("using entrypoint: %s\n", entrypoint)
+
+	//endregion
+
+	//region Command arguments
+
+	// Set the first command line argument as the project name
+	var args = []string{fmt.Sprintf("-PixelStreamingIP=%s", cfg().App.PixelStreamingIP), fmt.Sprintf("-PixelStreamingPort=%d", cfg().App.PixelStreamingPort), "-RenderOffScreen", "-ForceRes", fmt.Sprintf("-ResX=%d", cfg().App.ResX), fmt.Sprintf("-ResY=%d", cfg().App.ResY)}
+	// Append additional command line arguments if any of them present
+	args = append(args, appArgs...)
+
+	//endregion
+
+	for {
+		restart, err := m.runAppProcess(ctx, entrypoint, projectDir, releaseDir, args, newHookEnv(session, id, releaseDir))
+		if err != nil {
+			abortSession(ctx, session, err)
+		}
+		if !restart {
+			return
+		}
+
+		logrus.Infof("restarting the application")
+		metrics.AppRestarts.Inc()
+		analytics.RecordRestart()
+		launcherEvents.Publish(eventSessionRestart, map[string]interface{}{
+			"sessionId": session.Id,
+		})
+		err = SetSessionStatus(ctx, session.Id, session.AppId, "starting")
+		if err != nil {
+			logrus.Errorf("failed to set session status to starting: %s\n", err.Error())
+		}
+	}
+}
+
+// runAppProcess starts the app process and waits for it to exit, returns true if the app should be restarted. The error
+// is returned if the app has failed to start, the sandbox is removed by then.
+func (m *sessionManager) runAppProcess(ctx context.Context, entrypoint string, projectDir string, releaseDir string, args []string, hookEnv hooks.Env) (restart bool, err error) {
+	session := m.Session()
+
+	//region Prepare and run the server command
+	cmd := exec.Command(entrypoint, args...)
+	cmd.Dir = projectDir // Change the current working directory for the process to the PROJECT_DIR
+
+	sandbox, err := newAppSandbox(sessionSandboxDir(session.Id), cfg().App.EnvAllowlist, cfg().App.User, cfg().App.Group)
+	if err != nil {
+		return false, fmt.Errorf("failed to create the application sandbox: %w", err)
+	}
+
+	control, cleanupLimits, err := m.startAppProcess(ctx, cmd, sandbox, releaseDir, hookEnv)
+	if err != nil {
+		sandbox.Cleanup()
+		return false, err
+	}
+
+	launcherEvents.Publish(eventProcessStarted, map[string]interface{}{
+		"sessionId": session.Id,
+		"pid":       cmd.Process.Pid,
+	})
+
+	// Cancelled when the application process exits, not derived from the launcher context as the process may outlive it
+	appCtx, appCancel := context.WithCancel(context.Background())
+	defer appCancel()
+
+	//region Resource accounting
+
+	sampler := newAppSampler(ctx, session, cmd.Process.Pid)
+	go sampler.Run(appCtx)
+
+	//endregion
+
+	// The maximum duration is measured from the session claim, so restarting the app does not extend the session
+	watchdog := newSessionWatchdog(m.ClaimedAt(), cfg().Session.MaxDuration, cfg().Session.IdleTimeout, cfg().Session.StopWarning, m.liveness)
+
+	startTime, err := process.StartTime(cmd.Process.Pid)
+	if err != nil && !errors.Is(err, process.ErrStartTimeNotSupported) {
+		logrus.Errorf("failed to get the application process start time: %s\n", err.Error())
+	}
+
+	m.mu.Lock()
+	m.proc = startedProcess{cmd.Process}
+	m.appCtx = appCtx
+	m.sampler = sampler
+	m.watchdog = watchdog
+	m.startTime = startTime
+	m.mu.Unlock()
+
+	m.saveState()
+
+	//region Readiness probe
+
+	// Switch the session to running only after the app has registered as a streamer at the signalling server
+	var startupFailed atomic.Bool
+	go m.superviseApp(ctx, appCtx, startedProcess{cmd.Process}, watchdog, control, &startupFailed)
+
+	//endregion
+
+	err = cmd.Wait()
+	appCancel()
+
+	//region Post-session hooks and cleanup
+
+	exitCode := cmd.ProcessState.ExitCode()
+	hookEnv.ExitCode = &exitCode
+
+	launcherEvents.Publish(eventProcessExited, map[string]interface{}{
+		"sessionId": session.Id,
+		"pid":       cmd.Process.Pid,
+		"exitCode":  exitCode,
+	})
+
+	// A failed post-session hook configured to abort the session becomes the session close reason
+	closeReason := runPostSessionHooks(hooks.PostExit, hookEnv)
+
+	cleanupLimits()
+	sandbox.Cleanup()
+
+	if reason := runPostSessionHooks(hooks.PostCleanup, hookEnv); closeReason == "" {
+		closeReason = reason
+	}
+
+	//endregion
+
+	m.mu.Lock()
+	stopReason := m.stopReason
+	restart = m.restartRequested && stopReason == ""
+	m.restartRequested = false
+	if restart {
+		m.restarts++
+	} else {
+		m.phase = phaseClosed
+	}
+	m.mu.Unlock()
+
+	if !restart {
+		m.clearState()
+
+		switch {
+		case startupFailed.Load():
+			analytics.Finish("failed", errStartupTimeout.Error(), exitCode)
+		case stopReason != "":
+			analytics.Finish("closed", stopReason, exitCode)
+		default:
+			analytics.Finish("closed", closeReason, exitCode)
+		}
+	}
+
+	if startupFailed.Load() {
+		log.Fatalf("the application has failed to start: %v\n", err)
+	}
+
+	if restart {
+		log.Printf("the application has been stopped for restart: %v\n", err)
+		return true, nil
+	}
+
+	if stopReason != "" {
+		log.Printf("the application has been stopped by the launcher (%s): %v\n", stopReason, err)
+		return false, nil
+	}
+
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			// The program has exited with an exit code != 0
+			// This usually means that the server process has crashed
+			err = SetSessionStatusWithReason(ctx, session.Id, session.AppId, "closed", closeReason)
+			if err != nil {
+				log.Fatalf("failed to set session status to starting: %s\n", err.Error())
+			}
+
+			if status, ok := exitError.Sys().(syscall.WaitStatus); ok {
+				log.Fatalf("application exit code: %v\n", status.ExitStatus())
+			}
+		} else {
+			err = SetSessionStatusWithReason(ctx, session.Id, session.AppId, "closed", closeReason)
+			if err != nil {
+				log.Fatalf("failed to set session status to starting: %s\n", err.Error())
+			}
+			log.Fatalf("application exit error: %v\n", err)
+		}
+	} else {
+		log.Printf("application exited normally\n")
+		err = SetSessionStatusWithReason(ctx, session.Id, session.AppId, "closed", closeReason)
+		if err != nil {
+			log.Fatalf("failed to set session status to starting: %s\n", err.Error())
+		}
+	}
+
+	//endregion
+
+	return false, nil
+}
+
+// startAppProcess applies the sandbox to the command, runs the pre-launch hooks and starts the app process with the
+// resource limits applied, the returned cleanup removes the limits after the process exits
+func (m *sessionManager) startAppProcess(ctx context.Context, cmd *exec.Cmd, sandbox *appSandbox, releaseDir string, hookEnv hooks.Env) (control *appControlChannel, cleanupLimits func(), err error) {
+	session := m.Session()
+
+	cmd.Env = sandbox.Environ(os.Environ())
+	err = sandbox.Apply(cmd, releaseDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to apply the application sandbox: %w", err)
+	}
+
+	rd, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to attach to a application stdout pipe: %w", err)
+	}
+	wr, err := cmd.StdinPipe()
+	if err != nil {
+		_ = rd.Close()
+		return nil, nil, fmt.Errorf("failed to attach to a application stdin pipe: %w", err)
+	}
+
+	err = appHooks.Run(ctx, hooks.PreLaunch, hookEnv)
+	if err != nil {
+		_ = rd.Close()
+		_ = wr.Close()
+		return nil, nil, err
+	}
+
+	m.SetPhase(phaseStarting)
+
+	// The resource limits are applied before the application runs, the pipes are closed if the start fails
+	cleanupLimits, err = process.StartLimited(cmd, "veverse-session-"+session.Id.String(), appLimits())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start the application: %w", err)
+	}
+
+	// Read output from the server process
+	go func() {
+		b := make([]byte, 2048)
+		for {
+			nn, err := rd.Read(b)
+			if nn > 0 {
+				log.Printf("%s", b[:nn])
+			}
+			if err != nil {
+				if err == io.EOF {
+					log.Printf("the application process has exited\n")
+				} else {
+					log.Fatalf("failed to read the application process pipe: %s\n", err.Error())
+				}
+				return
+			}
+		}
+	}()
+
+	return newAppControlChannel(wr), cleanupLimits, nil
+}
+
+// superviseApp waits for the app to register at the signalling server and enforces the session limits until the app
+// exits, the app is killed if it does not register in time. The control channel is nil for the adopted app.
+func (m *sessionManager) superviseApp(ctx context.Context, appCtx context.Context, proc appProcess, watchdog *sessionWatchdog, control *appControlChannel, startupFailed *atomic.Bool) {
+	session := m.Session()
+
+	address := net.JoinHostPort(cfg().App.PixelStreamingIP, strconv.Itoa(cfg().App.PixelStreamingPort))
+	err := waitForReadiness(appCtx, address, cfg().Session.StartupTimeout)
+	if err != nil {
+		if !errors.Is(err, errStartupTimeout) {
+			return
+		}
+
+		startupFailed.Store(true)
+		logrus.Errorf("the application has not registered at the signalling server %s in %s", address, cfg().Session.StartupTimeout)
+
+		err = SetSessionStatusWithReason(ctx, session.Id, session.AppId, "failed", errStartupTimeout.Error())
+		if err != nil {
+			logrus.Errorf("failed to set session status to failed: %s\n", err.Error())
+		}
+
+		err = proc.Signal(os.Kill)
+		if err != nil {
+			logrus.Errorf("failed to kill the application process: %s\n", err.Error())
+		}
+		return
+	}
+
+	m.SetPhase(phaseRunning)
+	err = SetSessionStatus(ctx, session.Id, session.AppId, "running")
+	if err != nil {
+		logrus.Errorf("failed to set session status to running: %s\n", err.Error())
+	}
+
+	//region Session limits
+
+	watchdog.Run(appCtx, func(reason string, in time.Duration) {
+		logrus.Infof("warning the application about the session stop in %s: %s", in, reason)
+
+		// The signalling web server relays the warning to the game and the viewers over the Pixel Streaming data channel
+		launcherEvents.Publish(eventSessionStopWarning, map[string]interface{}{
+			"sessionId": session.Id,
+			"reason":    reason,
+			"seconds":   int(in.Seconds()),
+		})
+
+		if control == nil {
+			// The standard input of the adopted app is not available
+			return
+		}
+
+		err := control.Send("session-stop-warning", map[string]interface{}{
+			"reason":  reason,
+			"seconds": int(in.Seconds()),
+		})
+		if err != nil {
+			logrus.Errorf("failed to warn the application: %s\n", err.Error())
+		}
+	}, func(reason string) {
+		err := m.Close(ctx, reason)
+		if err != nil {
+			logrus.Errorf("failed to close the session: %s\n", err.Error())
+		}
+	})
+
+	//endregion
+}
+
+// newAppSampler creates the resource usage sampler of the app process reporting the samples as the session metrics
+func newAppSampler(ctx context.Context, session *sm.PixelStreamingSessionData, pid int) *process.Sampler {
+	return process.NewSampler(pid, cfg().App.SampleInterval, func(stats process.Stats) {
+		logrus.Debugf("application resource usage: rss %d bytes, cpu %.1f%%, open files %d", stats.RSSBytes, stats.CPUPercent, stats.OpenFiles)
+		metrics.AppRSS.Set(float64(stats.RSSBytes))
+		metrics.AppCPUSeconds.Set(stats.CPUSeconds)
+		metrics.AppCPUPercent.Set(stats.CPUPercent)
+		metrics.AppOpenFiles.Set(float64(stats.OpenFiles))
+		err := ReportSessionMetrics(ctx, session.Id, stats)
+		if err != nil {
+			logrus.Errorf("failed to report session metrics: %s\n", err.Error())
+		}
+	})
+}
+
+// sessionSandboxDir returns the sandbox directory of the session
+func sessionSandboxDir(id *uuid.UUID) string {
+	return filepath.Join(cfg().Dirs.Temp, cfg().Dirs.Sessions, id.String())
+}
+
+// appReleaseDir returns the directory the app release is installed to
+func appReleaseDir(appId uuid.UUID, r *sm.ReleaseV2) string {
+	return filepath.Join(cfg().Dirs.Apps, appId.String(), r.Id.String()+"-"+r.Version)
+}
+
+// newHookEnv creates the hooks environment for the session and the app release directory
+func newHookEnv(session *sm.PixelStreamingSessionData, appId uuid.UUID, releaseDir string) hooks.Env {
+	releaseDir, err := filepath.Abs(releaseDir)
+	if err != nil {
+		logrus.Errorf("failed to get the release directory: %s\n", err.Error())
+	}
+
+	return hooks.Env{
+		SessionId:  session.Id.String(),
+		AppId:      appId.String(),
+		ReleaseDir: releaseDir,
+	}
+}
+
+// runPostSessionHooks runs the post-session hooks of the stage. The hooks are not bound to the launcher context, which
+// is cancelled once the session is closed by the client before the app exits, each hook is bounded by its timeout
+// instead. Returns the close reason if a hook configured to abort the session has failed.
+func runPostSessionHooks(stage hooks.Stage, env hooks.Env) string {
+	err := appHooks.Run(context.Background(), stage, env)
+
+	var abortErr *hooks.AbortError
+	if errors.As(err, &abortErr) {
+		logrus.Errorf("%s\n", abortErr.Error())
+		return abortErr.Reason()
+	}
+
+	return ""
+}
+
+// abortSession marks the session as failed with the hook failure reason and exits the launcher
+func abortSession(ctx context.Context, session *sm.PixelStreamingSessionData, err error) {
+	reason := err.Error()
+	var abortErr *hooks.AbortError
+	if errors.As(err, &abortErr) {
+		reason = abortErr.Reason()
+	}
+
+	err1 := SetSessionStatusWithReason(ctx, session.Id, session.AppId, "failed", reason)
+	if err1 != nil {
+		logrus.Errorf("failed to set session status to failed: %s\n", err1.Error())
+	}
+
+	analytics.Finish("failed", reason, -1)
+	if err1 = removeSessionState(); err1 != nil {
+		logrus.Errorf("%s\n", err1.Error())
+	}
+
+	log.Fatalf("the session has been aborted: %s\n", err.Error())
+}
+
+// appProcess is the app process started by the launcher or adopted after the launcher restart
+type appProcess interface {
+	PID() int
+	Signal(sig os.Signal) error
+}
+
+// startedProcess is the app process started by the launcher
+type startedProcess struct {
+	p *os.Process
+}
+
+// PID returns the process id
+func (p startedProcess) PID() int {
+	return p.p.Pid
+}
+
+// Signal sends the signal to the process
+func (p startedProcess) Signal(sig os.Signal) error {
+	return p.p.Signal(sig)
+}
+
+// stopApp asks the app process to exit and kills it if it is still running after the timeout, appCtx is cancelled when the process exits
+func stopApp(appCtx context.Context, proc appProcess, timeout time.Duration) {
+	err := proc.Signal(os.Interrupt)
+	if err != nil {
+		// Interrupt is not supported on Windows
+		err = proc.Signal(os.Kill)
+		if err != nil {
+			logrus.Errorf("failed to kill the application process: %s\n", err.Error())
+		}
+		return
+	}
+
+	select {
+	case <-appCtx.Done():
+	case <-time.After(timeout):
+		logrus.Warningf("the application has not exited in %s, killing it", timeout)
+		err = proc.Signal(os.Kill)
+		if err != nil {
+			logrus.Errorf("failed to kill the application process: %s\n", err.Error())
+		}
+	}
+}
+
+// appLimits returns the configured process resource limits of the app
+func appLimits() process.Limits {
+	return process.Limits{
+		MemoryBytes: cfg().App.MemoryLimitMB * 1024 * 1024,
+		CPUWeight:   cfg().App.CPUWeight,
+		OpenFiles:   cfg().App.OpenFilesLimit,
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"veverse-pixel-streaming-launcher/utils"
+)
+
+// sessionStateFile is the file the current session is persisted to, relative to the temp directory
+const sessionStateFile = "session.json"
+
+// sessionStateMu serializes the session state file writes
+var sessionStateMu sync.Mutex
+
+// sessionState is the current session persisted across the launcher restarts
+type sessionState struct {
+	SessionId  *uuid.UUID `json:"sessionId"`
+	AppId      *uuid.UUID `json:"appId"`
+	Phase      string     `json:"phase"`
+	ReleaseDir string     `json:"releaseDir,omitempty"`
+	Pid        int        `json:"pid,omitempty"`
+	StartTime  uint64     `json:"startTime,omitempty"` // Tells the app process apart from a later process reusing its pid
+	ClaimedAt  time.Time  `json:"claimedAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+// sessionStatePath returns the path of the session state file
+func sessionStatePath() string {
+	return filepath.Join(cfg().Dirs.Temp, sessionStateFile)
+}
+
+// loadSessionState loads the session persisted by the previous launcher run, nil if there is no session
+func loadSessionState() (*sessionState, error) {
+	b, err := os.ReadFile(sessionStatePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read session state: %w", err)
+	}
+
+	var state sessionState
+	err = json.Unmarshal(b, &state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse session state: %w", err)
+	}
+
+	return &state, nil
+}
+
+// saveSessionState persists the session state, the offline session is not persisted
+func saveSessionState(state sessionState) error {
+	if offlineSession != nil {
+		return nil
+	}
+
+	state.UpdatedAt = time.Now().UTC()
+
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal session state: %w", err)
+	}
+
+	sessionStateMu.Lock()
+	defer sessionStateMu.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(sessionStatePath()), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create session state directory: %w", err)
+	}
+
+	err = utils.WriteFileAtomic(sessionStatePath(), b)
+	if err != nil {
+		return fmt.Errorf("failed to write session state: %w", err)
+	}
+
+	return nil
+}
+
+// removeSessionState removes the session state once the session has ended
+func removeSessionState() error {
+	sessionStateMu.Lock()
+	defer sessionStateMu.Unlock()
+
+	err := os.Remove(sessionStatePath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove session state: %w", err)
+	}
+
+	return nil
+}
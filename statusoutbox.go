@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"path/filepath"
+	"time"
+	"veverse-pixel-streaming-launcher/outbox"
+)
+
+// outboxSessionStatus is the outbox message kind of the session status updates
+const outboxSessionStatus = "session-status"
+
+// outboxFlushTime is the interval the status updates spooled during the API outage are retried at
+const outboxFlushTime = 15 * time.Second
+
+// statusOutbox spools the session status updates until the API accepts them, nil until opened
+var statusOutbox *outbox.Outbox
+
+// sessionStatusUpdate is the session status update kept in the status outbox
+type sessionStatusUpdate struct {
+	SessionId *uuid.UUID `json:"sessionId"`
+	AppId     *uuid.UUID `json:"appId"`
+	Status    string     `json:"status"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// openStatusOutbox opens the status outbox and delivers the updates left by the previous run until the context is cancelled
+func openStatusOutbox(ctx context.Context) error {
+	dir := filepath.Join(cfg().Dirs.Temp, cfg().Dirs.Outbox, "status")
+
+	o, err := outbox.Open(dir, deliverStatusMessage)
+	if err != nil {
+		return err
+	}
+
+	if n := o.Len(); n > 0 {
+		logrus.Infof("replaying %d session status updates left by the previous run", n)
+		if err = o.Flush(ctx); err != nil {
+			logrus.Warningf("failed to replay session status updates, will retry: %s", err.Error())
+		}
+	}
+
+	statusOutbox = o
+	go o.Run(ctx, outboxFlushTime)
+
+	return nil
+}
+
+// deliverStatusMessage sends the spooled status update to the API
+func deliverStatusMessage(ctx context.Context, m outbox.Message) error {
+	switch m.Kind {
+	case outboxSessionStatus:
+		var update sessionStatusUpdate
+		if err := json.Unmarshal(m.Payload, &update); err != nil {
+			return outbox.Permanent(fmt.Errorf("failed to decode session status update: %w", err))
+		}
+		return sendSessionStatus(ctx, update)
+	default:
+		return outbox.Permanent(fmt.Errorf("unknown outbox message kind %s", m.Kind))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"veverse-pixel-streaming-launcher/events"
+)
+
+// Launcher event types streamed by the events endpoint
+const (
+	eventSessionStatus      = "session.status"
+	eventSessionPhase       = "session.phase"
+	eventSessionRestart     = "session.restart"
+	eventSessionStopWarning = "session.stop-warning"
+	eventDownloadProgress   = "download.progress"
+	eventExtractProgress    = "extract.progress"
+	eventProcessStarted     = "process.started"
+	eventProcessExited      = "process.exited"
+)
+
+// launcherEvents is the broker of the launcher events
+var launcherEvents = events.NewBroker(1000)
+
+// progressThrottle is the minimum interval between the progress events
+var progressThrottle = time.Duration(1) * time.Second
+
+// publishSessionStatus publishes the session status change event
+func publishSessionStatus(id *uuid.UUID, status string, reason string) {
+	launcherEvents.Publish(eventSessionStatus, map[string]interface{}{
+		"sessionId": id,
+		"status":    status,
+		"reason":    reason,
+	})
+}
+
+// publishProgress returns the progress callback publishing the progress events at most once per progressThrottle and on completion
+func publishProgress(eventType string, data map[string]interface{}) func(current uint64, total uint64) {
+	var publishedAt time.Time
+	return func(current uint64, total uint64) {
+		if current < total && time.Since(publishedAt) < progressThrottle {
+			return
+		}
+		publishedAt = time.Now()
+
+		e := map[string]interface{}{
+			"current": current,
+			"total":   total,
+		}
+		for k, v := range data {
+			e[k] = v
+		}
+		launcherEvents.Publish(eventType, e)
+	}
+}
+
+// streamEvents streams the launcher events as Server-Sent Events. A reconnecting client receives the events it has
+// missed after the event in the Last-Event-ID header or the lastEventId query parameter.
+func (s *controlServer) streamEvents(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+
+	var lastId uint64
+	if lastEventId != "" {
+		var err error
+		lastId, err = strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid last event id")
+			return
+		}
+	}
+
+	// The connection is taken over from the server, so the server write timeout does not close the long-lived stream
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		logrus.Errorf("failed to hijack the event stream connection: %s\n", err.Error())
+		return
+	}
+
+	defer func(conn net.Conn) {
+		if err := conn.Close(); err != nil {
+			logrus.Errorf("failed to close the event stream connection: %s\n", err.Error())
+		}
+	}(conn)
+
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		logrus.Errorf("failed to reset the event stream connection deadline: %s\n", err.Error())
+		return
+	}
+
+	missed, ch, unsubscribe := s.events.Subscribe(lastId)
+	defer unsubscribe()
+
+	_, err = buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nCache-Control: no-cache\r\nConnection: close\r\n\r\n")
+	if err != nil {
+		return
+	}
+
+	for _, e := range missed {
+		if err = writeEvent(buf, e); err != nil {
+			return
+		}
+	}
+	if err = buf.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(time.Duration(15) * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-keepalive.C:
+			// Writing to the closed connection also detects the disconnected client
+			if _, err = fmt.Fprint(buf, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-ch:
+			if !ok {
+				// The client has been dropped as too slow, it has to reconnect
+				return
+			}
+			if err = writeEvent(buf, e); err != nil {
+				return
+			}
+		}
+
+		if err = buf.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes the event in the Server-Sent Events format
+func writeEvent(w io.Writer, e events.Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		logrus.Errorf("failed to marshal event: %s\n", err.Error())
+		return nil
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, e.Type, b)
+	return err
+}
@@ -0,0 +1,2 @@
+// Package system provides the host resource information reported by the launcher instance heartbeat.
+package system
@@ -0,0 +1,42 @@
+//go:build linux
+
+package system
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FreeDisk returns the disk space available to the launcher on the volume of the path, in bytes.
+func FreeDisk(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	err := unix.Statfs(path, &stat)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat the volume: %w", err)
+	}
+
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// Load returns the one minute load average of the host.
+func Load() (float64, error) {
+	b, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read load average: %w", err)
+	}
+
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("failed to parse load average")
+	}
+
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse load average: %w", err)
+	}
+
+	return load, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !windows
+
+package system
+
+import "fmt"
+
+// FreeDisk is not supported on this platform.
+func FreeDisk(_ string) (uint64, error) {
+	return 0, fmt.Errorf("free disk space is supported on linux and windows only")
+}
+
+// Load is not supported on this platform.
+func Load() (float64, error) {
+	return 0, fmt.Errorf("load average is supported on linux only")
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package system
+
+import (
+	"fmt"
+	"golang.org/x/sys/windows"
+)
+
+// FreeDisk returns the disk space available to the launcher on the volume of the path, in bytes.
+func FreeDisk(path string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, fmt.Errorf("invalid path %s: %w", path, err)
+	}
+
+	var available, total, free uint64
+	err = windows.GetDiskFreeSpaceEx(p, &available, &total, &free)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the volume free space: %w", err)
+	}
+
+	return available, nil
+}
+
+// Load is not supported on this platform.
+func Load() (float64, error) {
+	return 0, fmt.Errorf("load average is not supported on windows")
+}
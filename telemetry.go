@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"time"
+	"veverse-pixel-streaming-launcher/database"
+	"veverse-pixel-streaming-launcher/telemetry"
+)
+
+// launcherEventsTable is the table of the launcher and session events
+const launcherEventsTable = "launcher_events"
+
+// telemetryStopTimeout is the time the remaining telemetry events are flushed for when the launcher exits
+const telemetryStopTimeout = 5 * time.Second
+
+// telemetryQueue is the queue of the launcher telemetry events, nil until the telemetry is started
+var telemetryQueue *telemetry.Queue
+
+// newTelemetrySink creates the configured telemetry sink, the no-op sink is used if the sink is not available
+func newTelemetrySink() telemetry.Sink {
+	c := cfg().Telemetry
+
+	switch c.Sink {
+	case "clickhouse":
+		if database.Clickhouse == nil {
+			logrus.Warningf("clickhouse is not configured, the telemetry is disabled")
+			return telemetry.NopSink{}
+		}
+		return telemetry.NewClickHouseSink(database.Clickhouse)
+	case "file":
+		sink, err := telemetry.NewFileSink(c.File)
+		if err != nil {
+			logrus.Errorf("failed to create telemetry file sink, the telemetry is disabled: %s\n", err.Error())
+			return telemetry.NopSink{}
+		}
+		return sink
+	default:
+		return telemetry.NopSink{}
+	}
+}
+
+// startTelemetry starts writing the launcher events to the telemetry sink until the context is cancelled
+func startTelemetry(ctx context.Context) {
+	c := cfg().Telemetry
+
+	sink := newTelemetrySink()
+	if _, ok := sink.(telemetry.NopSink); ok {
+		return
+	}
+
+	spill := c.SpillDir
+	if c.Sink == "file" {
+		// The file sink never fails for the database reasons, there is nothing to spill
+		spill = ""
+	}
+
+	telemetryQueue = telemetry.NewQueue(sink, telemetry.Options{
+		Size:          c.QueueSize,
+		BatchSize:     c.BatchSize,
+		FlushInterval: c.FlushInterval,
+		WriteTimeout:  c.WriteTimeout,
+		SpillDir:      spill,
+		MaxSpillBytes: c.MaxSpillMB * 1024 * 1024,
+	})
+	go telemetryQueue.Run(ctx)
+	go forwardLauncherEvents(ctx, telemetryQueue)
+}
+
+// stopTelemetry waits for the telemetry queue to flush the remaining events after the launcher context is cancelled
+func stopTelemetry() {
+	if telemetryQueue == nil {
+		return
+	}
+
+	select {
+	case <-telemetryQueue.Done():
+	case <-time.After(telemetryStopTimeout):
+		logrus.Warningf("the telemetry events have not been flushed in %s", telemetryStopTimeout)
+	}
+}
+
+// forwardLauncherEvents emits the launcher events published to the event stream as the telemetry events
+func forwardLauncherEvents(ctx context.Context, q *telemetry.Queue) {
+	_, ch, unsubscribe := launcherEvents.Subscribe(0)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(e.Data)
+			if err != nil {
+				logrus.Errorf("failed to marshal event data: %s\n", err.Error())
+				continue
+			}
+
+			q.Emit(launcherEventsTable, map[string]interface{}{
+				"instance_id": instanceId,
+				"type":        e.Type,
+				"data":        string(data),
+			})
+		}
+	}
+}
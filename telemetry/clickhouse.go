@@ -0,0 +1,114 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"sort"
+	"strings"
+)
+
+// ClickHouseSink writes the events to the ClickHouse tables, each event data key is written to the column of the same name.
+type ClickHouseSink struct {
+	conn driver.Conn
+}
+
+// NewClickHouseSink creates a new ClickHouseSink.
+func NewClickHouseSink(conn driver.Conn) *ClickHouseSink {
+	return &ClickHouseSink{conn: conn}
+}
+
+// Write inserts the events in batches grouped by the table and the columns.
+func (s *ClickHouseSink) Write(ctx context.Context, events []Event) error {
+	for i := 0; len(events) > 0; i++ {
+		table, columns := events[0].Table, columns(events[0])
+
+		// Group the consecutive events of the same shape into one insert
+		n := 1
+		for n < len(events) && events[n].Table == table && sameColumns(columns, events[n]) {
+			n++
+		}
+
+		insertCtx := ctx
+		if id := BatchId(ctx); id != "" {
+			// ClickHouse skips the insert with the token of an already written insert
+			insertCtx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+				"insert_deduplication_token": fmt.Sprintf("%s-%d", id, i),
+			}))
+		}
+
+		err := s.insert(insertCtx, table, columns, events[:n])
+		if err != nil {
+			return err
+		}
+
+		events = events[n:]
+	}
+
+	return nil
+}
+
+// insert inserts the events of the same shape into the table
+func (s *ClickHouseSink) insert(ctx context.Context, table string, columns []string, events []Event) error {
+	query := fmt.Sprintf("INSERT INTO %s (time, %s)", table, strings.Join(columns, ", "))
+	if len(columns) == 0 {
+		query = fmt.Sprintf("INSERT INTO %s (time)", table)
+	}
+
+	batch, err := s.conn.PrepareBatch(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare telemetry batch: %w", err)
+	}
+
+	for _, e := range events {
+		values := make([]interface{}, 0, len(columns)+1)
+		values = append(values, e.Time)
+		for _, c := range columns {
+			values = append(values, e.Data[c])
+		}
+
+		if err = batch.Append(values...); err != nil {
+			_ = batch.Abort()
+			return fmt.Errorf("failed to append telemetry event: %w", err)
+		}
+	}
+
+	err = batch.Send()
+	if err != nil {
+		return fmt.Errorf("failed to send telemetry batch: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the connection.
+func (s *ClickHouseSink) Close() error {
+	return s.conn.Close()
+}
+
+// columns returns the sorted data keys of the event
+func columns(e Event) []string {
+	keys := make([]string, 0, len(e.Data))
+	for k := range e.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// sameColumns checks if the event has exactly the columns
+func sameColumns(columns []string, e Event) bool {
+	if len(columns) != len(e.Data) {
+		return false
+	}
+
+	for _, c := range columns {
+		if _, ok := e.Data[c]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
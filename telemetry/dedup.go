@@ -0,0 +1,18 @@
+package telemetry
+
+import "context"
+
+// batchIdKey is the context key of the batch id
+type batchIdKey struct{}
+
+// WithBatchId returns the context carrying the id of the written batch, the sinks use it to deduplicate the batch
+// written again after a failure.
+func WithBatchId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, batchIdKey{}, id)
+}
+
+// BatchId returns the batch id of the context, empty if not set.
+func BatchId(ctx context.Context) string {
+	id, _ := ctx.Value(batchIdKey{}).(string)
+	return id
+}
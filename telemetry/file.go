@@ -0,0 +1,59 @@
+package telemetry
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileSink appends the events to the local file as JSON lines.
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSink creates a new FileSink writing to the file at the path, the directory is created if missing.
+func NewFileSink(path string) (*FileSink, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create telemetry directory: %w", err)
+	}
+
+	return &FileSink{path: path}, nil
+}
+
+// Write appends the events to the file.
+func (s *FileSink) Write(_ context.Context, events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open telemetry file: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err = enc.Encode(e); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("failed to encode telemetry event: %w", err)
+		}
+	}
+
+	if err = w.Flush(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write telemetry file: %w", err)
+	}
+
+	return f.Close()
+}
+
+// Close does nothing, the file is opened for each write.
+func (s *FileSink) Close() error {
+	return nil
+}
@@ -0,0 +1,220 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/sirupsen/logrus"
+	"time"
+	"veverse-pixel-streaming-launcher/metrics"
+	"veverse-pixel-streaming-launcher/outbox"
+)
+
+// spillKind is the outbox message kind of the spilled event batches
+const spillKind = "telemetry"
+
+// Options configures the Queue.
+type Options struct {
+	Size          int           // Maximum number of the events waiting in memory, new events are dropped once it is full
+	BatchSize     int           // Maximum number of the events written at once
+	FlushInterval time.Duration // Maximum time the events wait for the batch to fill
+	WriteTimeout  time.Duration // Timeout of a single batch write
+	SpillDir      string        // Outbox the events are spilled to while the sink is unavailable, empty disables the spill
+	MaxSpillBytes int64         // Maximum size of the spilled events, the events are dropped once it is reached
+}
+
+// Queue passes the events to the sink in batches in the background, so emitting an event never blocks. The batches
+// failed to be written are spilled to disk and replayed once the sink is available again.
+type Queue struct {
+	sink    Sink
+	opts    Options
+	events  chan Event
+	spill   *outbox.Outbox
+	flushes chan chan struct{}
+	done    chan struct{}
+}
+
+// NewQueue creates a new Queue writing to the sink.
+func NewQueue(sink Sink, opts Options) *Queue {
+	q := &Queue{
+		sink:    sink,
+		opts:    opts,
+		events:  make(chan Event, opts.Size),
+		flushes: make(chan chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if opts.SpillDir != "" {
+		spill, err := outbox.Open(opts.SpillDir, q.replayBatch)
+		if err != nil {
+			logrus.Errorf("failed to create telemetry spill, the events are dropped while the sink is unavailable: %s\n", err.Error())
+		} else {
+			q.spill = spill
+		}
+	}
+
+	return q
+}
+
+// Emit queues the event without blocking, the event is dropped if the queue is full.
+func (q *Queue) Emit(table string, data map[string]interface{}) {
+	if q == nil {
+		return
+	}
+
+	select {
+	case q.events <- Event{Table: table, Time: time.Now().UTC(), Data: data}:
+	default:
+		metrics.TelemetryEvents.Inc("dropped")
+	}
+}
+
+// Run writes the queued events until the context is cancelled, then flushes the remaining events and closes the sink.
+func (q *Queue) Run(ctx context.Context) {
+	defer close(q.done)
+
+	ticker := time.NewTicker(q.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, q.opts.BatchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			q.flush(q.drain(batch))
+			if err := q.sink.Close(); err != nil {
+				logrus.Errorf("failed to close telemetry sink: %s\n", err.Error())
+			}
+			return
+		case reply := <-q.flushes:
+			q.flush(q.drain(batch))
+			batch = batch[:0]
+			close(reply)
+			continue
+		case e := <-q.events:
+			batch = append(batch, e)
+			if len(batch) < q.opts.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			q.replay()
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		q.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// Flush writes the queued events and waits until they are written or spilled, or the context is done.
+func (q *Queue) Flush(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+
+	reply := make(chan struct{})
+	select {
+	case q.flushes <- reply:
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed after the queue has stopped and flushed the remaining events.
+func (q *Queue) Done() <-chan struct{} {
+	return q.done
+}
+
+// drain appends the queued events to the batch without waiting for new ones
+func (q *Queue) drain(batch []Event) []Event {
+	for {
+		select {
+		case e := <-q.events:
+			batch = append(batch, e)
+		default:
+			return batch
+		}
+	}
+}
+
+// flush writes the batch to the sink, spilling it to disk on failure
+func (q *Queue) flush(batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		logrus.Errorf("failed to generate telemetry batch id: %s\n", err.Error())
+	}
+
+	err = q.write(id.String(), batch)
+	if err == nil {
+		metrics.TelemetryEvents.Add(float64(len(batch)), "written")
+		return
+	}
+
+	logrus.Warningf("failed to write %d telemetry events: %s\n", len(batch), err.Error())
+
+	if q.spill == nil || (q.opts.MaxSpillBytes > 0 && q.spill.Size() >= q.opts.MaxSpillBytes) {
+		metrics.TelemetryEvents.Add(float64(len(batch)), "dropped")
+		return
+	}
+
+	_, err = q.spill.Append(spillKind, id.String(), batch)
+	if err != nil {
+		logrus.Errorf("failed to spill telemetry events: %s\n", err.Error())
+		metrics.TelemetryEvents.Add(float64(len(batch)), "dropped")
+		return
+	}
+
+	metrics.TelemetryEvents.Add(float64(len(batch)), "spilled")
+}
+
+// replay writes the spilled batches to the sink in order, the batches are kept if the sink is still unavailable
+func (q *Queue) replay() {
+	if q.spill == nil || q.spill.Len() == 0 {
+		return
+	}
+
+	err := q.spill.Flush(context.Background())
+	if err != nil {
+		logrus.Debugf("failed to replay the spilled telemetry events: %s", err.Error())
+	}
+}
+
+// replayBatch writes the spilled batch, the batch id lets the sink skip the batch already written before the failure
+func (q *Queue) replayBatch(_ context.Context, m outbox.Message) error {
+	var batch []Event
+	err := json.Unmarshal(m.Payload, &batch)
+	if err != nil {
+		return outbox.Permanent(fmt.Errorf("failed to decode spilled telemetry events: %w", err))
+	}
+
+	err = q.write(m.Key, batch)
+	if err != nil {
+		return err
+	}
+
+	metrics.TelemetryEvents.Add(float64(len(batch)), "replayed")
+	return nil
+}
+
+// write writes the batch with the write timeout
+func (q *Queue) write(id string, batch []Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), q.opts.WriteTimeout)
+	defer cancel()
+
+	return q.sink.Write(WithBatchId(ctx, id), batch)
+}
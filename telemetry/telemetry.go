@@ -0,0 +1,92 @@
+// Package telemetry provides the analytics sinks of the launcher and the non-blocking queue writing the events to them.
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a single telemetry row written to the table of the sink. The data values are strings, int64, float64 or
+// bool, so the events keep their column types after the spill to disk.
+type Event struct {
+	Table string                 `json:"table"`
+	Time  time.Time              `json:"time"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+// Sink writes the telemetry event batches, e.g. to the database.
+type Sink interface {
+	Write(ctx context.Context, events []Event) error
+	Close() error
+}
+
+// NopSink discards the events, used when the telemetry is disabled.
+type NopSink struct{}
+
+// Write discards the events.
+func (NopSink) Write(context.Context, []Event) error {
+	return nil
+}
+
+// Close does nothing.
+func (NopSink) Close() error {
+	return nil
+}
+
+// MarshalJSON encodes the event keeping the decimal point of the float data values, so they are decoded back as floats.
+func (e Event) MarshalJSON() ([]byte, error) {
+	type event Event
+	v := event(e)
+
+	v.Data = make(map[string]interface{}, len(e.Data))
+	for k, value := range e.Data {
+		if f, ok := value.(float64); ok && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			n := strconv.FormatFloat(f, 'f', -1, 64)
+			if !strings.ContainsAny(n, ".eE") {
+				n += ".0"
+			}
+			value = json.Number(n)
+		}
+		v.Data[k] = value
+	}
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes the event restoring the integer data values as int64 instead of float64.
+func (e *Event) UnmarshalJSON(b []byte) error {
+	type event Event
+	var v struct {
+		event
+		Data map[string]json.RawMessage `json:"data"`
+	}
+
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+
+	*e = Event(v.event)
+	e.Data = make(map[string]interface{}, len(v.Data))
+	for k, raw := range v.Data {
+		var value interface{}
+		if err = json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+
+		if _, ok := value.(float64); ok && !strings.ContainsAny(string(raw), ".eE") {
+			var i int64
+			if json.Unmarshal(raw, &i) == nil {
+				value = i
+			}
+		}
+
+		e.Data[k] = value
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package utils
 
 import (
 	"archive/zip"
+	"context"
 	"dev.hackerman.me/artheon/veverse-shared/executable"
 	"fmt"
 	"github.com/gofrs/uuid"
@@ -15,8 +16,9 @@ import (
 	"veverse-pixel-streaming-launcher/config"
 )
 
-// ExtractArchive extracts the given archive to the given destination path.
-func ExtractArchive(archivePath string, destinationPath string) error {
+// ExtractArchive extracts the given archive to the given destination path, the optional progress callback receives the number of extracted and total files.
+// The extraction stops between the files once the context is cancelled.
+func ExtractArchive(ctx context.Context, archivePath string, destinationPath string, progress func(current uint64, total uint64)) error {
 	logrus.Printf("extracting archive %s to %s", archivePath, destinationPath)
 
 	r, err := zip.OpenReader(archivePath)
@@ -83,11 +85,20 @@ func ExtractArchive(archivePath string, destinationPath string) error {
 		return nil
 	}
 
-	for _, f := range r.File {
+	total := uint64(len(r.File))
+	for i, f := range r.File {
+		if err = ctx.Err(); err != nil {
+			return fmt.Errorf("extraction cancelled: %w", err)
+		}
+
 		err = extractAndWriteFile(f)
 		if err != nil {
 			return fmt.Errorf("failed to extract file: %w", err)
 		}
+
+		if progress != nil {
+			progress(uint64(i+1), total)
+		}
 	}
 
 	return nil
@@ -447,3 +458,26 @@ func ClearUserData() error {
 
 	return err
 }
+
+// WriteFileAtomic replaces the file content through a temporary file, so the file is never left partially written.
+func WriteFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
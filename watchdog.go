@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Session close reason codes reported when the launcher stops the app by itself
+const (
+	closeReasonMaxDuration = "max-duration"
+	closeReasonIdleTimeout = "idle-timeout"
+)
+
+// sessionWatchdog enforces the maximum session duration and the idle timeout driven by the viewers tracked by the liveness tracker
+type sessionWatchdog struct {
+	mu          sync.Mutex
+	startedAt   time.Time     // Session start the maximum duration is measured from
+	maxDuration time.Duration // Hard limit of the session duration, zero means no limit
+	idleTimeout time.Duration // Grace period the session may stay without connected viewers, zero means no limit
+	warning     time.Duration // Time between the warning sent to the app and the session stop
+	liveness    *livenessTracker
+}
+
+// newSessionWatchdog creates a new sessionWatchdog
+func newSessionWatchdog(startedAt time.Time, maxDuration time.Duration, idleTimeout time.Duration, warning time.Duration, liveness *livenessTracker) *sessionWatchdog {
+	return &sessionWatchdog{
+		startedAt:   startedAt,
+		maxDuration: maxDuration,
+		idleTimeout: idleTimeout,
+		warning:     warning,
+		liveness:    liveness,
+	}
+}
+
+// SetLimits updates the session limits of the running watchdog, e.g. after the configuration is reloaded
+func (w *sessionWatchdog) SetLimits(maxDuration time.Duration, idleTimeout time.Duration, warning time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.maxDuration = maxDuration
+	w.idleTimeout = idleTimeout
+	w.warning = warning
+}
+
+// limits returns the current session limits
+func (w *sessionWatchdog) limits() (maxDuration time.Duration, idleTimeout time.Duration, warning time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.maxDuration, w.idleTimeout, w.warning
+}
+
+// Run checks the session limits until the context is cancelled, calling warn before the session is stopped and stop once a limit is reached
+func (w *sessionWatchdog) Run(ctx context.Context, warn func(reason string, in time.Duration), stop func(reason string)) {
+	// Give the viewers the grace period to connect to the just started app
+	w.liveness.MarkActive()
+
+	ticker := time.NewTicker(cfg().Session.WatchdogCheckInterval)
+	defer ticker.Stop()
+
+	var maxDurationWarned, idleWarned bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		maxDuration, idleTimeout, warning := w.limits()
+
+		if maxDuration > 0 {
+			left := maxDuration - now.Sub(w.startedAt)
+			if left <= 0 {
+				logrus.Infof("the session has reached the maximum duration of %s", maxDuration)
+				stop(closeReasonMaxDuration)
+				return
+			} else if left <= warning && !maxDurationWarned {
+				maxDurationWarned = true
+				warn(closeReasonMaxDuration, left)
+			}
+		}
+
+		if idleTimeout > 0 {
+			idle := w.liveness.IdleSince(now)
+			if idle == 0 {
+				// Viewers have reconnected, warn again if the session becomes idle once more
+				idleWarned = false
+				continue
+			}
+
+			left := idleTimeout - idle
+			if left <= 0 {
+				logrus.Infof("the session has had no connected viewers for %s", idleTimeout)
+				stop(closeReasonIdleTimeout)
+				return
+			} else if left <= warning && !idleWarned {
+				idleWarned = true
+				warn(closeReasonIdleTimeout, left)
+			}
+		}
+	}
+}